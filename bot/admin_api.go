@@ -0,0 +1,107 @@
+package bot
+
+import (
+	"context"
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+// registerAdminAPI adds the admin API to a router. It's only served when
+// ADMIN_API_TOKEN is set, and every request needs it as a bearer token.
+// Endpoints:
+// GET /api/servers/(server)/config
+// PUT /api/servers/(server)/config/(setting)
+// DELETE /api/servers/(server)/config/(setting)
+// GET /api/servers/(server)/config/history
+// POST /api/servers/(server)/config/revert/(change)
+func (b *AmputatorBot) registerAdminAPI(app *gin.Engine) {
+	if b.Config.AdminAPIToken == "" {
+		return
+	}
+	api := app.Group("/api", b.requireAdminToken)
+	api.GET("/servers/:server/config", b.adminGetConfig)
+	api.PUT("/servers/:server/config/:setting", b.adminSetConfig)
+	api.DELETE("/servers/:server/config/:setting", b.adminResetConfig)
+	api.GET("/servers/:server/config/history", b.adminConfigHistory)
+	api.POST("/servers/:server/config/revert/:change", b.adminRevertConfig)
+}
+
+// requireAdminToken rejects requests without the admin API token.
+func (b *AmputatorBot) requireAdminToken(c *gin.Context) {
+	token, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+	if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(b.Config.AdminAPIToken)) != 1 {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid or missing token"})
+		return
+	}
+	c.Next()
+}
+
+// adminGetConfig returns every setting on a server, by name.
+func (b *AmputatorBot) adminGetConfig(c *gin.Context) {
+	var registration ServerRegistration
+	b.DB.Where(&ServerRegistration{DiscordId: c.Param("server")}).Find(&registration)
+	if registration.DiscordId == "" {
+		c.JSON(http.StatusNotFound, gin.H{"error": "unknown server"})
+		return
+	}
+	sc := b.getServerConfig(c.Param("server"))
+	settings := map[string]string{}
+	for _, setting := range configSettings {
+		settings[setting.Name] = setting.value(sc)
+	}
+	c.JSON(http.StatusOK, settings)
+}
+
+// adminSetConfig sets a setting on a server to the value in the request
+// body, such as {"value": "on"}.
+func (b *AmputatorBot) adminSetConfig(c *gin.Context) {
+	var body struct {
+		Value string `json:"value" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	logger := log.WithFields(log.Fields{"guild": c.Param("server"), "source": configSourceAPI})
+	setting, err := b.setSetting(context.Background(), b.DG, c.Param("server"), c.Param("setting"), body.Value,
+		nil, configSourceAPI, logger)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{setting.Name: setting.value(b.getServerConfig(c.Param("server")))})
+}
+
+// adminResetConfig sets a setting on a server back to its default.
+func (b *AmputatorBot) adminResetConfig(c *gin.Context) {
+	logger := log.WithFields(log.Fields{"guild": c.Param("server"), "source": configSourceAPI})
+	err := b.resetSettings(context.Background(), b.DG, c.Param("server"), c.Param("setting"),
+		nil, configSourceAPI, logger)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	setting, _ := lookUpSetting(c.Param("setting"))
+	c.JSON(http.StatusOK, gin.H{setting.Name: setting.value(b.getServerConfig(c.Param("server")))})
+}
+
+// adminConfigHistory lists a server's most recent config changes.
+func (b *AmputatorBot) adminConfigHistory(c *gin.Context) {
+	c.JSON(http.StatusOK, b.configHistory(c.Request.Context(), c.Param("server"), maxConfigHistoryLength))
+}
+
+// adminRevertConfig reverts a config change on a server.
+func (b *AmputatorBot) adminRevertConfig(c *gin.Context) {
+	logger := log.WithFields(log.Fields{"guild": c.Param("server"), "source": configSourceAPI})
+	change, err := b.revertConfigChange(context.Background(), b.DG, c.Param("server"), c.Param("change"),
+		nil, configSourceAPI, logger)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, change)
+}
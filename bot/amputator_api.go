@@ -0,0 +1,168 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	goamputate "github.com/tyzbit/go-amputate"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+)
+
+// amputatorHTTPClient is used for calls to the Amputator API. Its transport
+// is traced so each call shows up as a child of the resolution span.
+// Timeouts come from the per-attempt context instead of the client.
+var amputatorHTTPClient = TracedHTTPClient(&http.Client{})
+
+var (
+	// retryBackoffBase is the backoff before the first retry. It doubles
+	// with every attempt and is jittered.
+	retryBackoffBase time.Duration = time.Millisecond * 250
+)
+
+// apiStatusError is returned when the Amputator API replies with anything
+// other than 200.
+type apiStatusError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e apiStatusError) Error() string {
+	return fmt.Sprintf("amputator api returned status %v: %v", e.StatusCode, e.Body)
+}
+
+// retryable reports whether an error from the Amputator API is worth
+// retrying: network errors, timeouts, 429s and 5xxs. Malformed responses
+// and other 4xxs won't get better by asking again.
+func retryable(err error) bool {
+	var statusErr apiStatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.StatusCode == http.StatusTooManyRequests || statusErr.StatusCode >= 500
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+	return errors.Is(err, context.DeadlineExceeded) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// newAPIBreaker returns the circuit breaker guarding the Amputator API,
+// configured from the bot's config.
+func newAPIBreaker(config AmputatorBotConfig) *circuitBreaker {
+	threshold := config.APIBreakerThreshold
+	if threshold <= 0 {
+		threshold = defaultAPIBreakerThreshold
+	}
+	cooldown := time.Duration(config.APIBreakerCooldownSeconds) * time.Second
+	if cooldown <= 0 {
+		cooldown = defaultAPIBreakerCooldown
+	}
+	return newCircuitBreaker(threshold, cooldown)
+}
+
+// amputateWithRetries calls the Amputator API with a timeout on every
+// attempt, retrying retryable errors with jittered exponential backoff.
+func (bot *AmputatorBot) amputateWithRetries(ctx context.Context, requestURL string, sc ServerConfig) ([]string, error) {
+	timeout := time.Duration(bot.Config.APITimeoutSeconds) * time.Second
+	if timeout <= 0 {
+		timeout = defaultAPITimeout
+	}
+	// Unset means the default, negative means no retries
+	retries := bot.Config.APIRetries
+	if retries == 0 {
+		retries = defaultAPIRetries
+	}
+	if retries < 0 {
+		retries = 0
+	}
+
+	for attempt := 0; ; attempt++ {
+		attemptCtx, cancel := context.WithTimeout(ctx, timeout)
+		urls, err := bot.amputate(attemptCtx, requestURL, sc)
+		cancel()
+		var statusErr apiStatusError
+		if errors.As(err, &statusErr) && statusErr.StatusCode == http.StatusTooManyRequests {
+			bot.logToServer(ctx, bot.DG, sc.DiscordId, logRateLimits,
+				translate(responseLanguage(sc, ""), "The Amputator API rate limited the bot"), log.WithField("guild", sc.DiscordId))
+		}
+		if err == nil || !retryable(err) || attempt >= retries || ctx.Err() != nil {
+			return urls, err
+		}
+
+		// Full jitter: wait a random amount up to the exponential backoff
+		backoff := retryBackoffBase << attempt
+		select {
+		case <-time.After(time.Duration(rand.Int63n(int64(backoff) + 1))):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// amputatorAPIURL returns the configured base URL for the Amputator API,
+// falling back to the public API.
+func (bot *AmputatorBot) amputatorAPIURL() string {
+	if bot.Config.AmputatorAPIURL != "" {
+		return strings.TrimSuffix(bot.Config.AmputatorAPIURL, "/")
+	}
+	return defaultAmputatorAPIURL
+}
+
+// amputate calls the Amputator API for a single URL and returns the
+// canonical URLs it found. The response is parsed with goamputate so the
+// shape stays the same as the upstream library expects.
+func (bot *AmputatorBot) amputate(ctx context.Context, requestURL string, sc ServerConfig) ([]string, error) {
+	ctx, span := tracer.Start(ctx, "amputatorAPI")
+	defer span.End()
+	span.SetAttributes(attribute.String("amputation.request_url", requestURL))
+
+	query := url.Values{}
+	query.Set("gac", fmt.Sprintf("%v", sc.GuessAndCheck))
+	query.Set("md", fmt.Sprintf("%v", sc.MaxDepth))
+	query.Set("q", requestURL)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, bot.amputatorAPIURL()+"/convert?"+query.Encode(), nil)
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+	req.Header.Add("User-Agent", amputatorUserAgent)
+
+	res, err := amputatorHTTPClient.Do(req)
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return nil, fmt.Errorf("unable to call amputator api: %w", err)
+	}
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return nil, fmt.Errorf("unable to read amputator api response: %w", err)
+	}
+	span.SetAttributes(attribute.Int("http.response.status_code", res.StatusCode))
+
+	if res.StatusCode != http.StatusOK {
+		err := apiStatusError{StatusCode: res.StatusCode, Body: string(body)}
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+
+	urls, err := goamputate.GetCanonicalUrls(body)
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+	span.SetAttributes(attribute.Int("amputation.response_urls", len(urls)))
+
+	return urls, nil
+}
@@ -1,47 +1,106 @@
 package bot
 
 import (
-	"regexp"
+	"context"
 	"strings"
 	"time"
 
 	"github.com/bwmarrin/discordgo"
 	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"gorm.io/gorm"
 )
 
 type AmputatorBot struct {
 	DB         *gorm.DB
-	DG         *discordgo.Session
+	DG         Session
 	Config     AmputatorBotConfig
 	StartingUp bool
+
+	breaker     *circuitBreaker
+	scans       *scanTracker
+	webhooks    *webhookCache
+	prefixes    *prefixCache
+	throttles   *throttle
+	recentLinks *linkMemory
+	rateLimits  *rateLimitLog
+	rules       *ruleCache
+	checkpoints *checkpointCache
 }
 
 type AmputatorBotConfig struct {
-	AdminIds   []string `env:"ADMINISTRATOR_IDS"`
-	DBHost     string   `env:"DB_HOST"`
-	DBName     string   `env:"DB_NAME"`
-	DBPassword string   `env:"DB_PASSWORD"`
-	DBUser     string   `env:"DB_USER"`
-	LogLevel   string   `env:"LOG_LEVEL"`
-	Token      string   `env:"TOKEN"`
+	AdminIds                  []string `env:"ADMINISTRATOR_IDS"`
+	AdminAPIToken             string   `env:"ADMIN_API_TOKEN"`
+	AmputatorAPIURL           string   `env:"AMPUTATOR_API_URL"`
+	APIBreakerCooldownSeconds int      `env:"AMPUTATOR_API_BREAKER_COOLDOWN_SECONDS"`
+	APIBreakerThreshold       int      `env:"AMPUTATOR_API_BREAKER_THRESHOLD"`
+	APIRetries                int      `env:"AMPUTATOR_API_RETRIES"`
+	APITimeoutSeconds         int      `env:"AMPUTATOR_API_TIMEOUT_SECONDS"`
+	CatchUpMaxAgeMinutes      int      `env:"CATCH_UP_MAX_AGE_MINUTES"`
+	CatchUpMaxMessages        int      `env:"CATCH_UP_MAX_MESSAGES"`
+	DBHost                    string   `env:"DB_HOST"`
+	DBName                    string   `env:"DB_NAME"`
+	DBPassword                string   `env:"DB_PASSWORD"`
+	DBUser                    string   `env:"DB_USER"`
+	LogFormat                 string   `env:"LOG_FORMAT"`
+	LogLevel                  string   `env:"LOG_LEVEL"`
+	LogOutput                 string   `env:"LOG_OUTPUT"`
+	Token                     string   `env:"TOKEN"`
+	TraceEndpoint             string   `env:"TRACE_ENDPOINT"`
+	TraceExporter             string   `env:"TRACE_EXPORTER"`
+}
+
+// NewAmputatorBot returns a bot that uses db for storage and dg to talk to
+// Discord.
+func NewAmputatorBot(db *gorm.DB, dg Session, config AmputatorBotConfig) *AmputatorBot {
+	return &AmputatorBot{
+		DB:          db,
+		DG:          dg,
+		Config:      config,
+		StartingUp:  true,
+		breaker:     newAPIBreaker(config),
+		scans:       newScanTracker(),
+		webhooks:    newWebhookCache(),
+		prefixes:    newPrefixCache(),
+		throttles:   newThrottle(),
+		recentLinks: newLinkMemory(),
+		rateLimits:  newRateLimitLog(),
+		rules:       newRuleCache(),
+		checkpoints: newCheckpointCache(),
+	}
 }
 
 // BotReady is called when the bot is considered ready to use the Discord session.
 func (bot *AmputatorBot) BotReady(s *discordgo.Session, r *discordgo.Ready) {
+	bot.botReady(DiscordSession{s}, r)
+}
+
+func (bot *AmputatorBot) botReady(s Session, r *discordgo.Ready) {
 	for _, g := range r.Guilds {
 		err := bot.registerOrUpdateGuild(s, g)
 		if err != nil {
-			log.Errorf("unable to register or update guild: %v", err)
+			guildLogger(g).WithError(err).Error("unable to register or update guild")
 		}
 	}
 
+	// Ready is also sent after reconnecting, so anything posted while the
+	// bot was away is handled in the background. Messages from now on
+	// arrive as they're posted.
+	go bot.catchUp(s, snowflakeAt(time.Now()))
+
 	if bot.StartingUp {
-		time.Sleep(time.Second * 10)
+		go bot.flushCheckpointsPeriodically()
+
+		if r.Application != nil {
+			bot.registerApplicationCommands(s, r.Application.ID)
+		}
+
+		time.Sleep(startupDelay)
 		bot.StartingUp = false
 		err := bot.updateServersWatched(s)
 		if err != nil {
-			log.Error("unable to update servers watched")
+			log.WithError(err).Error("unable to update servers watched")
 		}
 	}
 }
@@ -49,63 +108,118 @@ func (bot *AmputatorBot) BotReady(s *discordgo.Session, r *discordgo.Ready) {
 // GuildCreate is called whenever the bot joins a new guild. It is also lazily called upon initial
 // connection to Discord.
 func (bot *AmputatorBot) GuildCreate(s *discordgo.Session, gc *discordgo.GuildCreate) {
+	bot.guildCreate(DiscordSession{s}, gc)
+}
+
+func (bot *AmputatorBot) guildCreate(s Session, gc *discordgo.GuildCreate) {
 	if gc.Guild.Unavailable {
 		return
 	}
 
 	err := bot.registerOrUpdateGuild(s, gc.Guild)
 	if err != nil {
-		log.Errorf("unable to register or update guild: %v", err)
+		guildLogger(gc.Guild).WithError(err).Error("unable to register or update guild")
 	}
 }
 
 // This function will be called (due to AddHandler above) every time a new
 // message is created on any channel that the authenticated bot has access to.
 func (bot *AmputatorBot) MessageCreate(s *discordgo.Session, m *discordgo.MessageCreate) {
+	bot.messageCreate(DiscordSession{s}, m)
+}
+
+func (bot *AmputatorBot) messageCreate(s Session, m *discordgo.MessageCreate) {
 	// This is a message the bot created itself
-	if m.Author != nil && s.State.User != nil && m.Author.ID == s.State.User.ID {
+	if m.Author != nil && s.BotUser() != nil && m.Author.ID == s.BotUser().ID {
 		bot.createMessageEvent("", m.Message)
 		return
 	}
 
-	// Check if a message has the command prefix (global variable)
-	if strings.HasPrefix(m.Content, commandPrefix) {
+	// The checkpoint only moves once the message is handled, so a message
+	// that was still being handled is caught up on after a crash
+	defer bot.saveCheckpoint(m.Message)
+
+	// This is a message the bot reposted in replace mode
+	if bot.isOwnRepost(m.Message) {
+		return
+	}
+
+	ctx, span := tracer.Start(context.Background(), "MessageCreate", trace.WithAttributes(
+		attribute.String("discord.guild", m.GuildID),
+		attribute.String("discord.channel", m.ChannelID),
+		attribute.String("discord.message", m.ID),
+	))
+	defer span.End()
+
+	logger := messageLogger(m.Message)
+	if span.SpanContext().IsValid() {
+		logger = logger.WithField("trace_id", span.SpanContext().TraceID().String())
+	}
+
+	// Check if a message starts with the server's command prefix or a
+	// mention of the bot
+	if command, ok := bot.commandMessage(s, m.Message); ok {
 		var err error
 		bot.createMessageEvent(statsCommand, m.Message)
 
-		words := strings.Split(m.Content, " ")
+		words := strings.Split(command.Content, " ")
 		if len(words) < 2 {
-			log.Warn("not enough words for ", statsCommand, " command")
+			logger.Warn("not enough words for command")
 			return
 		}
 
 		verb := words[1]
-		log.Info(verb+" called by ", m.Author.Username, "(", m.Author.ID, ")")
+		logger = logger.WithField("command", verb)
+		if !bot.managesServer(ctx, s, m.Message) &&
+			bot.rateLimited(ctx, s, m.Message, bot.getMessageConfig(m.Message), nil, logger) {
+			return
+		}
+		logger.Info("command called")
 		switch verb {
 		case statsCommand:
-			err = bot.handleMessageWithStats(s, m)
+			err = bot.handleMessageWithStats(ctx, s, &discordgo.MessageCreate{Message: command}, logger)
 		case configCommand:
-			err = bot.setServerConfig(s, m.Message)
+			err = bot.setServerConfig(ctx, s, command, logger)
+		case templateCommand:
+			err = bot.handleTemplateCommand(ctx, s, command, logger)
+		case reviewCommand:
+			err = bot.handleReviewCommand(ctx, s, command, logger)
+		case ruleCommand:
+			err = bot.handleRuleCommand(ctx, s, command, logger)
+		case scanCommand:
+			err = bot.handleScanCommand(ctx, s, command, logger)
 		default:
-			log.Warn("unknown command ", verb, " called")
+			logger.Warn("unknown command called")
 		}
 
 		if err != nil {
-			log.Warn("problem handling ", configCommand, " command: ", err)
+			logger.WithError(err).Warn("problem handling command")
 		}
 		return
 	}
 
-	// Check if the message has something that looks like an AMP URL according
-	// to the ampRegex.
-	match, _ := regexp.Match(ampRegex, []byte(m.Content))
-	if match {
+	// Check if the message has any URLs that look like AMP URLs. The
+	// config is only looked up for messages with links in them.
+	links := messageLinks(m.Message)
+	if len(links) == 0 {
+		return
+	}
+	sc := bot.getMessageConfig(m.Message)
+	if (m.Author.Bot || m.WebhookID != "") && !sc.ProcessBots {
+		logger.Debug("ignoring links from a bot or webhook")
+		return
+	}
+	links = filterAmpLinks(links, sc.Sensitivity)
+	if len(links) > 0 {
+		if bot.rateLimited(ctx, s, m.Message, sc, links, logger) {
+			return
+		}
 		bot.createMessageEvent("", m.Message)
 
-		log.Debug("message appears to have an AMP URL: ", m.Content)
-		err := bot.handleMessageWithAmpUrls(s, m)
+		logger.WithField("content", m.Content).Debug("message appears to have an AMP URL")
+		err := bot.handleMessageWithAmpUrls(ctx, s, m, links, logger)
 		if err != nil {
-			log.Warn("unable to handle message with AMP urls: %w", err)
+			logger.WithError(err).Warn("unable to handle message with AMP urls")
 		}
 		return
 	}
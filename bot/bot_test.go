@@ -1,11 +1,21 @@
 package bot
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
 	"reflect"
+	"slices"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/bwmarrin/discordgo"
 	log "github.com/sirupsen/logrus"
+	"github.com/tyzbit/go-discord-amputator/fakeapi"
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
 )
@@ -17,11 +27,19 @@ var (
 		&Amputation{},
 		&AmputationEvent{},
 		&MessageEvent{},
+		&InteractionEvent{},
+		&AmputationReport{},
+		&URLOverride{},
+		&RewriteRule{},
+		&ChannelCheckpoint{},
+		&ConfigChange{},
+		&RateLimitEvent{},
 	}
 )
 
-func testInit() AmputatorBot {
-	db, err := gorm.Open(sqlite.Open("./test.sqlite"))
+func testInit(t *testing.T) (*AmputatorBot, *fakeSession) {
+	// Every test gets its own in-memory database
+	db, err := gorm.Open(sqlite.Open(fmt.Sprintf("file:%v?mode=memory&cache=shared", t.Name())))
 	if err != nil {
 		log.Error("unable to set up db: ", err)
 	}
@@ -34,23 +52,1549 @@ func testInit() AmputatorBot {
 		}
 	}
 
-	return AmputatorBot{
-		DB:         db,
-		DG:         &discordgo.Session{StateEnabled: true, State: discordgo.NewState()},
-		StartingUp: true,
-	}
+	startupDelay = 0
+	scanDelay = 0
+	rateLimitBatchDelay = time.Millisecond * 20
+	retryBackoffBase = time.Millisecond
+	s := newFakeSession()
+	s.addGuild(&discordgo.Guild{ID: "200", Name: "Test Guild"})
+
+	return NewAmputatorBot(db, s, AmputatorBotConfig{}), s
+}
+
+// allowLocalPages lets pages be fetched from httptest servers for the rest
+// of the test.
+func allowLocalPages(t *testing.T) {
+	allowPrivateAddresses = true
+	t.Cleanup(func() { allowPrivateAddresses = false })
+}
+
+// testAPI points the bot at a fake Amputator API for the rest of the test.
+func testAPI(t *testing.T, ampBot *AmputatorBot) *fakeapi.Server {
+	fake := fakeapi.New()
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+	ampBot.Config.AmputatorAPIURL = server.URL
+	return fake
+}
+
+// testMessage builds a message from a user in the test guild.
+func testMessage(content string) *discordgo.MessageCreate {
+	return &discordgo.MessageCreate{Message: &discordgo.Message{
+		ID:        "300",
+		ChannelID: "400",
+		GuildID:   "200",
+		Content:   content,
+		Author:    &discordgo.User{ID: "500", Username: "User Name"},
+	}}
 }
 
 func TestBotReady(t *testing.T) {
-	ampBot := testInit()
-	_ = ampBot.DG.State.GuildAdd(&discordgo.Guild{ID: "guild"})
-	_ = ampBot.DG.State.MemberAdd(&discordgo.Member{
-		User: &discordgo.User{
-			ID:       "user",
-			Username: "User Name",
-		},
-		Nick:    "User Nick",
-		GuildID: "guild",
+	ampBot, s := testInit(t)
+	ampBot.botReady(s, &discordgo.Ready{Guilds: []*discordgo.Guild{{ID: "200"}}})
+
+	var registration ServerRegistration
+	ampBot.DB.Where(&ServerRegistration{DiscordId: "200"}).Find(&registration)
+	if registration.Name != "Test Guild" {
+		t.Errorf("expected guild to be registered, got %+v", registration)
+	}
+	if ampBot.StartingUp {
+		t.Error("expected bot to have finished starting up")
+	}
+	if len(s.statuses) == 0 {
+		t.Error("expected bot status to be updated")
+	}
+}
+
+func TestMessageCreateAmputatesCachedURL(t *testing.T) {
+	ampBot, s := testInit(t)
+	ampBot.DB.Create(&Amputation{
+		UUID:               "cached",
+		RequestURL:         "https://example.com/amp/story",
+		ResponseURL:        "https://example.com/story",
+		ResponseDomainName: "example.com",
+	})
+
+	ampBot.messageCreate(s, testMessage("look at https://example.com/amp/story"))
+
+	sent := s.sentMessages()
+	if len(sent) != 1 || len(sent[0].Embeds) != 1 {
+		t.Fatalf("expected one embed reply, got %+v", sent)
+	}
+	if sent[0].Embeds[0].Description != "https://example.com/story" {
+		t.Errorf("unexpected reply: %v", sent[0].Embeds[0].Description)
+	}
+
+	var event AmputationEvent
+	ampBot.DB.Preload("Amputations").Where(&AmputationEvent{MessageId: "300"}).Find(&event)
+	if len(event.Amputations) != 1 || !event.Amputations[0].Cached {
+		t.Errorf("expected one cached amputation to be recorded, got %+v", event.Amputations)
+	}
+}
+
+func TestMessageCreateAmputatesDirectMessage(t *testing.T) {
+	ampBot, s := testInit(t)
+	ampBot.DB.Create(&Amputation{
+		UUID:               "cached",
+		ServerID:           "200",
+		RequestURL:         "https://example.com/amp/story",
+		ResponseURL:        "https://example.com/story",
+		ResponseDomainName: "example.com",
+	})
+
+	m := testMessage("https://example.com/amp/story")
+	m.GuildID = ""
+	ampBot.messageCreate(s, m)
+
+	sent := s.sentMessages()
+	if len(sent) != 1 || sent[0].Embeds[0].Description != "https://example.com/story" {
+		t.Fatalf("expected an amputated reply to the DM, got %+v", sent)
+	}
+
+	// The DM is only counted in the global stats
+	if urls := ampBot.getServerStats("200").URLsAmputated; urls != 1 {
+		t.Errorf("expected the DM to be left out of server stats, got %v urls", urls)
+	}
+	if urls := ampBot.getGlobalStats().URLsAmputated; urls != 2 {
+		t.Errorf("expected the DM to be in global stats, got %v urls", urls)
+	}
+}
+
+func TestConfigCommand(t *testing.T) {
+	ampBot, s := testInit(t)
+	ampBot.messageCreate(s, testMessage(commandPrefix+" config switch off"))
+
+	if ampBot.getServerConfig("200").AmputationEnabled {
+		t.Error("expected amputation to be disabled")
+	}
+	sent := s.sentMessages()
+	if len(sent) != 1 || sent[0].Embeds[0].Title != "Setting Updated" {
+		t.Fatalf("expected a setting updated reply, got %+v", sent)
+	}
+
+	// Amputation is off now, so nothing should be sent
+	ampBot.messageCreate(s, testMessage("https://example.com/amp/story"))
+	if len(s.sentMessages()) != 1 {
+		t.Errorf("expected no reply with amputation disabled, got %+v", s.sentMessages())
+	}
+}
+
+func TestLanguageCommand(t *testing.T) {
+	ampBot, s := testInit(t)
+	ampBot.messageCreate(s, testMessage(commandPrefix+" config language de"))
+
+	if language := ampBot.getServerConfig("200").Language; language != "de" {
+		t.Fatalf("expected language to be de, got %v", language)
+	}
+	ampBot.messageCreate(s, testMessage(commandPrefix+" stats"))
+
+	sent := s.sentMessages()
+	if len(sent) != 2 {
+		t.Fatalf("expected two replies, got %+v", sent)
+	}
+	if title := sent[0].Embeds[0].Title; title != "Einstellung aktualisiert" {
+		t.Errorf("expected the setting reply in German, got %v", title)
+	}
+	if title := sent[1].Embeds[0].Title; title != "Amputationsstatistik" {
+		t.Errorf("expected the stats reply in German, got %v", title)
+	}
+
+	ampBot.messageCreate(s, testMessage(commandPrefix+" config language xx"))
+	if language := ampBot.getServerConfig("200").Language; language != "de" {
+		t.Errorf("expected an unsupported language to be rejected, got %v", language)
+	}
+	sent = s.sentMessages()
+	want := "language muss eines von en, de, es sein, nicht xx"
+	if description := sent[len(sent)-1].Embeds[0].Description; !strings.HasPrefix(description, want) {
+		t.Errorf("expected the error in German, got %v", description)
+	}
+
+	// A user locale without translations falls back to the server's language
+	sc := ampBot.getServerConfig("200")
+	for locale, want := range map[discordgo.Locale]string{discordgo.SpanishES: "es", discordgo.French: "de", "": "de"} {
+		if got := responseLanguage(sc, locale); got != want {
+			t.Errorf("expected %v for locale %q, got %v", want, locale, got)
+		}
+	}
+}
+
+func TestTemplateCommand(t *testing.T) {
+	ampBot, s := testInit(t)
+	ampBot.messageCreate(s, testMessage(commandPrefix+" template set {bogus} {url}"))
+	if template := ampBot.getServerConfig("200").ReplyTemplate; template != "" {
+		t.Fatalf("expected an invalid template to be rejected, got %v", template)
+	}
+
+	// Only people who can manage the server can change the template
+	s.permissions["500"] = discordgo.PermissionSendMessages
+	ampBot.messageCreate(s, testMessage(commandPrefix+" template set {author} {url}"))
+	if template := ampBot.getServerConfig("200").ReplyTemplate; template != "" {
+		t.Fatalf("expected the template not to be set, got %v", template)
+	}
+	s.permissions["500"] = discordgo.PermissionAll
+
+	ampBot.messageCreate(s, testMessage(commandPrefix+" template set {author} {original} -> {url}"))
+	if template := ampBot.getServerConfig("200").ReplyTemplate; template != "{author} {original} -> {url}" {
+		t.Fatalf("expected the template to be set, got %v", template)
+	}
+
+	ampBot.DB.Create(&Amputation{
+		UUID:               "cached",
+		RequestURL:         "https://example.com/amp/story",
+		RequestDomainName:  "example.com",
+		ResponseURL:        "https://example.com/real-story",
+		ResponseDomainName: "example.com",
+	})
+	ampBot.messageCreate(s, testMessage("https://example.com/amp/story"))
+
+	sent := s.sentMessages()
+	want := "<@500> https://example.com/amp/story -> https://example.com/real-story"
+	if got := sent[len(sent)-1].Embeds[0].Description; got != want {
+		t.Errorf("expected reply %q, got %q", want, got)
+	}
+}
+
+func TestStatsCommand(t *testing.T) {
+	ampBot, s := testInit(t)
+	ampBot.messageCreate(s, testMessage(commandPrefix+" stats"))
+
+	sent := s.sentMessages()
+	if len(sent) != 1 || sent[0].Embeds[0].Title != "Amputation Stats" {
+		t.Fatalf("expected a stats reply, got %+v", sent)
+	}
+	for _, field := range sent[0].Embeds[0].Fields {
+		if strings.HasPrefix(field.Name, "Messages Acted On") && field.Value == "0" {
+			t.Error("expected the stats command itself to be counted")
+		}
+	}
+}
+
+func TestMessageCreateAmputatesWithAPI(t *testing.T) {
+	ampBot, s := testInit(t)
+	fake := testAPI(t, ampBot)
+	fake.Script("https://example.com/story?amp=1", fakeapi.Response{
+		Canonicals: []string{"https://example.com/real-story"},
+	})
+
+	ampBot.messageCreate(s, testMessage("https://example.com/story?amp=1"))
+
+	sent := s.sentMessages()
+	if len(sent) != 1 || sent[0].Embeds[0].Description != "https://example.com/real-story" {
+		t.Fatalf("expected the canonical url from the api, got %+v", sent)
+	}
+	if len(fake.Requests()) != 1 {
+		t.Errorf("expected one api call, got %v", fake.Requests())
+	}
+
+	// The second time around the result comes from the cache. It's posted
+	// in another channel so it isn't taken as a repeat of the first link.
+	m := testMessage("https://example.com/story?amp=1")
+	m.ID = "301"
+	m.ChannelID = "401"
+	ampBot.messageCreate(s, m)
+	if len(s.sentMessages()) != 2 {
+		t.Errorf("expected a reply from the cache, got %+v", s.sentMessages())
+	}
+	if len(fake.Requests()) != 1 {
+		t.Errorf("expected the cached result to be used, got %v", fake.Requests())
+	}
+}
+
+func TestMessageCreateAPIFailures(t *testing.T) {
+	for name, test := range map[string]struct {
+		response    fakeapi.Response
+		breakerOpen bool
+	}{
+		"error":       {response: fakeapi.Response{Status: http.StatusInternalServerError, Body: `{"error": "down"}`}, breakerOpen: true},
+		"rate_limit":  {response: fakeapi.Response{Status: http.StatusTooManyRequests, Body: "slow down"}, breakerOpen: true},
+		"bad_request": {response: fakeapi.Response{Status: http.StatusBadRequest, Body: "bad url"}},
+		"malformed":   {response: fakeapi.Response{Body: "not json"}},
+		"empty":       {response: fakeapi.Response{Body: "[]"}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			ampBot, s := testInit(t)
+			ampBot.Config.APIRetries = -1
+			ampBot.Config.APIBreakerThreshold = 1
+			ampBot.breaker = newAPIBreaker(ampBot.Config)
+			fake := testAPI(t, ampBot)
+			fake.Fallback(&test.response)
+
+			// The page itself 404s, so the canonical link fallback fails too
+			ampBot.messageCreate(s, testMessage(ampBot.Config.AmputatorAPIURL+"/story?amp=1"))
+
+			if len(s.sentMessages()) != 0 {
+				t.Errorf("expected no reply when the api fails, got %+v", s.sentMessages())
+			}
+			// Only failures of the api itself should open the breaker
+			if open := ampBot.breaker.State() == breakerOpen; open != test.breakerOpen {
+				t.Errorf("expected the breaker to be open: %v, got %v", test.breakerOpen, ampBot.breaker.State())
+			}
+		})
+	}
+}
+
+func TestCircuitBreakerFallsBackToCanonicalLink(t *testing.T) {
+	ampBot, s := testInit(t)
+	ampBot.Config.APIBreakerThreshold = 1
+	ampBot.breaker = newAPIBreaker(ampBot.Config)
+	fake := testAPI(t, ampBot)
+	fake.Fallback(&fakeapi.Response{Status: http.StatusServiceUnavailable, Body: "down"})
+	allowLocalPages(t)
+
+	pages := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `<html><head><link rel="canonical" href="%v"></head><body></body></html>`, r.URL.Path)
+	}))
+	defer pages.Close()
+
+	ampBot.messageCreate(s, testMessage(pages.URL+"/first?amp=1"))
+	if ampBot.breaker.State() != breakerOpen {
+		t.Errorf("expected the breaker to open, got %v", ampBot.breaker.State())
+	}
+	// One call plus the default number of retries
+	if len(fake.Requests()) != 1+defaultAPIRetries {
+		t.Errorf("expected the api call to be retried, got %v", fake.Requests())
+	}
+
+	// With the breaker open the api is skipped entirely
+	m := testMessage(pages.URL + "/second?amp=1")
+	m.ID = "301"
+	ampBot.messageCreate(s, m)
+	if len(fake.Requests()) != 1+defaultAPIRetries {
+		t.Errorf("expected no api calls with the breaker open, got %v", fake.Requests())
+	}
+
+	sent := s.sentMessages()
+	if len(sent) != 2 || sent[1].Embeds[0].Description != pages.URL+"/second" {
+		t.Fatalf("expected replies from the canonical link, got %+v", sent)
+	}
+	var amputation Amputation
+	ampBot.DB.Where(&Amputation{ResponseURL: pages.URL + "/second"}).Find(&amputation)
+	if amputation.Resolver != canonicalLinkResolverName {
+		t.Errorf("expected the resolver to be recorded, got %+v", amputation)
+	}
+}
+
+func TestPagesOnlyFetchedFromPublicAddresses(t *testing.T) {
+	for addr, public := range map[string]bool{
+		"93.184.215.14":   true,
+		"2606:4700::6810": true,
+		"127.0.0.1":       false,
+		"10.1.2.3":        false,
+		"172.16.0.1":      false,
+		"192.168.1.1":     false,
+		"169.254.169.254": false,
+		"100.64.0.1":      false,
+		"0.0.0.0":         false,
+		"::1":             false,
+		"fe80::1":         false,
+		"fd00::1":         false,
+		"::ffff:10.0.0.1": false,
+	} {
+		if got := publicAddress(netip.MustParseAddr(addr)); got != public {
+			t.Errorf("expected %v to be public: %v, got %v", addr, public, got)
+		}
+	}
+
+	ampBot, _ := testInit(t)
+	fetched := false
+	pages := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetched = true
+		fmt.Fprint(w, `<html><head><link rel="canonical" href="/internal"><title>Internal</title></head></html>`)
+	}))
+	defer pages.Close()
+
+	if _, err := (canonicalLinkResolver{}).Resolve(context.Background(), pages.URL+"/amp/x", ampBot.getServerConfig("200")); !errors.Is(err, errNonPublicAddress) {
+		t.Errorf("expected the canonical link resolver to refuse a local page, got %v", err)
+	}
+	if _, err := fetchPageMetadata(context.Background(), pages.URL+"/x"); !errors.Is(err, errNonPublicAddress) {
+		t.Errorf("expected page metadata to refuse a local page, got %v", err)
+	}
+	if fetched {
+		t.Error("expected the local page not to be fetched")
+	}
+	if _, err := fetchPageMetadata(context.Background(), "http://169.254.169.254/latest/meta-data/"); !errors.Is(err, errNonPublicAddress) {
+		t.Errorf("expected the link-local metadata address to be refused, got %v", err)
+	}
+}
+
+func TestPageRedirectsAreCapped(t *testing.T) {
+	redirects := 0
+	var pages *httptest.Server
+	pages = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		redirects++
+		http.Redirect(w, r, pages.URL+fmt.Sprintf("/amp/%v", redirects), http.StatusFound)
+	}))
+	defer pages.Close()
+	testInit(t)
+	allowLocalPages(t)
+
+	if _, err := (canonicalLinkResolver{}).Resolve(context.Background(), pages.URL+"/amp/0", ServerConfig{}); err == nil {
+		t.Error("expected endless redirects to fail")
+	}
+	if redirects != maxPageRedirects+1 {
+		t.Errorf("expected %v requests, got %v", maxPageRedirects+1, redirects)
+	}
+}
+
+func TestRichLayout(t *testing.T) {
+	ampBot, s := testInit(t)
+	fake := testAPI(t, ampBot)
+	ampBot.messageCreate(s, testMessage(commandPrefix+" config layout rich"))
+	allowLocalPages(t)
+
+	pages := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `<html><head>
+			<meta property="og:title" content="OpenGraph Title">
+			<meta property="og:site_name" content="Example News">
+			<meta property="og:image" content="/image.png">
+			<script type="application/ld+json">
+				{"@context": "https://schema.org", "@type": "NewsArticle",
+				 "headline": "Article Headline", "datePublished": "2024-05-01T12:00:00Z"}
+			</script>
+		</head><body></body></html>`)
+	}))
+	defer pages.Close()
+	fake.Script(pages.URL+"/amp/story", fakeapi.Response{Canonicals: []string{pages.URL + "/story"}})
+
+	ampBot.messageCreate(s, testMessage(pages.URL+"/amp/story"))
+
+	sent := s.sentMessages()
+	if len(sent) != 2 || len(sent[1].Embeds) != 1 {
+		t.Fatalf("expected one rich embed, got %+v", sent)
+	}
+	embed := sent[1].Embeds[0]
+	if embed.Title != "Article Headline" || embed.URL != pages.URL+"/story" {
+		t.Errorf("expected the JSON-LD headline and canonical url, got %+v", embed)
+	}
+	if embed.Author == nil || embed.Author.Name != "Example News" || embed.Author.IconURL != pages.URL+"/favicon.ico" {
+		t.Errorf("expected the OpenGraph publisher and favicon, got %+v", embed.Author)
+	}
+	if embed.Thumbnail == nil || embed.Thumbnail.URL != pages.URL+"/image.png" {
+		t.Errorf("expected the OpenGraph image, got %+v", embed.Thumbnail)
+	}
+	if embed.Timestamp != "2024-05-01T12:00:00Z" {
+		t.Errorf("expected the publish date, got %v", embed.Timestamp)
+	}
+
+	var amputation Amputation
+	ampBot.DB.Where(&Amputation{ResponseURL: pages.URL + "/story"}).Find(&amputation)
+	if amputation.Title != "Article Headline" {
+		t.Errorf("expected metadata to be cached with the amputation, got %+v", amputation)
+	}
+	if embed.Footer == nil || embed.Footer.Text != "Amputated from "+amputation.RequestDomainName {
+		t.Errorf("expected the original domain, got %+v", embed.Footer)
+	}
+
+	// Discord rejects author names and titles that are too long
+	long := strings.Repeat("ä", 300)
+	embed = richEmbed(Amputation{Title: long, Publisher: long, ResponseURL: "https://example.com/story"}, defaultLanguage)
+	if title := []rune(embed.Title); len(title) != maxEmbedTitleLength {
+		t.Errorf("expected the title to be truncated, got %v characters", len(title))
+	}
+	if name := []rune(embed.Author.Name); len(name) != maxEmbedAuthorLength || !strings.HasSuffix(embed.Author.Name, "...") {
+		t.Errorf("expected the publisher to be truncated, got %v characters", len(name))
+	}
+}
+
+func TestReplyButtons(t *testing.T) {
+	ampBot, s := testInit(t)
+	ampBot.DB.Create(&Amputation{
+		UUID:               "cached",
+		RequestURL:         "https://example.com/amp/story",
+		ResponseURL:        "https://example.com/story",
+		ResponseDomainName: "example.com",
+	})
+	ampBot.messageCreate(s, testMessage("https://example.com/amp/story"))
+
+	reply := s.sentMessages()[0]
+	if len(reply.Components) != 1 {
+		t.Fatalf("expected the reply to have buttons, got %+v", reply.Components)
+	}
+	var event AmputationEvent
+	ampBot.DB.Where(&AmputationEvent{MessageId: "300"}).Find(&event)
+	if event.ReplyMessageId != reply.ID {
+		t.Errorf("expected the reply to be linked to the event, got %v", event.ReplyMessageId)
+	}
+
+	click := func(action string, user *discordgo.User, permissions int64) {
+		ampBot.interactionCreate(s, &discordgo.InteractionCreate{Interaction: &discordgo.Interaction{
+			Type:      discordgo.InteractionMessageComponent,
+			GuildID:   "200",
+			ChannelID: "400",
+			Message:   reply,
+			Member:    &discordgo.Member{User: user, Permissions: permissions},
+			Data:      discordgo.MessageComponentInteractionData{CustomID: action + ":" + event.UUID},
+		}})
+	}
+
+	click(showOriginalAction, &discordgo.User{ID: "600"}, 0)
+	if got := s.responses[0].Data.Embeds[0].Description; got != "https://example.com/amp/story → https://example.com/story" {
+		t.Errorf("expected the original link to be shown, got %v", got)
+	}
+
+	click(deleteAction, &discordgo.User{ID: "600"}, 0)
+	if len(s.deleted) != 0 {
+		t.Fatal("expected someone else to not be able to delete the reply")
+	}
+	click(deleteAction, &discordgo.User{ID: "600"}, discordgo.PermissionManageMessages)
+	if len(s.deleted) != 1 || s.deleted[0] != reply.ID {
+		t.Errorf("expected a moderator to be able to delete the reply, got %v", s.deleted)
+	}
+
+	var interactions []InteractionEvent
+	ampBot.DB.Where(&InteractionEvent{AmputationEventUUID: event.UUID}).Find(&interactions)
+	if len(interactions) != 2 {
+		t.Errorf("expected two interactions to be recorded, got %+v", interactions)
+	}
+
+	// Plain text replies get their text changed, not Discord's link preview
+	reply = &discordgo.Message{
+		ID:        reply.ID,
+		ChannelID: reply.ChannelID,
+		Content:   "https://example.com/story",
+		Embeds:    []*discordgo.MessageEmbed{{Type: discordgo.EmbedTypeArticle, Description: "An article"}},
+	}
+	click(showOriginalAction, &discordgo.User{ID: "600"}, 0)
+	data := s.responses[len(s.responses)-1].Data
+	if data.Content != "https://example.com/amp/story → https://example.com/story" || data.Embeds != nil {
+		t.Errorf("expected the text to show the original link, got %q and %+v", data.Content, data.Embeds)
+	}
+}
+
+func TestDeleteSplitRichReply(t *testing.T) {
+	ampBot, s := testInit(t)
+	ampBot.messageCreate(s, testMessage(commandPrefix+" config layout rich"))
+
+	// More links than fit in one message split the reply in two
+	var links []string
+	for i := range maxEmbedsPerMessage + 1 {
+		link := fmt.Sprintf("https://example.com/amp/story%v", i)
+		ampBot.DB.Create(&Amputation{
+			UUID:               fmt.Sprintf("cached%v", i),
+			RequestURL:         link,
+			ResponseURL:        fmt.Sprintf("https://example.com/story%v", i),
+			ResponseDomainName: "example.com",
+			Title:              "A Story",
+		})
+		links = append(links, link)
+	}
+	ampBot.messageCreate(s, testMessage(strings.Join(links, " ")))
+
+	sent := s.sentMessages()
+	if len(sent) != 3 || len(sent[1].Components) != 0 || len(sent[2].Components) != 1 {
+		t.Fatalf("expected a reply in two parts with buttons on the last, got %+v", sent)
+	}
+	var event AmputationEvent
+	ampBot.DB.Where(&AmputationEvent{MessageId: "300"}).Find(&event)
+	if event.ReplyMessageId != sent[2].ID || event.ReplyPartIds != sent[1].ID {
+		t.Errorf("expected every part of the reply to be recorded, got %+v", event)
+	}
+
+	ampBot.interactionCreate(s, &discordgo.InteractionCreate{Interaction: &discordgo.Interaction{
+		Type:      discordgo.InteractionMessageComponent,
+		GuildID:   "200",
+		ChannelID: "400",
+		Message:   sent[2],
+		Member:    &discordgo.Member{User: &discordgo.User{ID: "500"}},
+		Data:      discordgo.MessageComponentInteractionData{CustomID: deleteAction + ":" + event.UUID},
+	}})
+	if !slices.Equal(s.deleted, []string{sent[2].ID, sent[1].ID}) {
+		t.Errorf("expected both parts of the reply to be deleted, got %v", s.deleted)
+	}
+}
+
+func TestReportReview(t *testing.T) {
+	ampBot, s := testInit(t)
+	ampBot.Config.AdminIds = []string{"700"}
+	ampBot.DB.Create(&Amputation{
+		UUID:               "cached",
+		RequestURL:         "https://example.com/amp/story",
+		ResponseURL:        "https://example.com/wrong-story",
+		ResponseDomainName: "example.com",
 	})
-	ampBot.BotReady(ampBot.DG, &ampBot.DG.State.Ready)
+	ampBot.messageCreate(s, testMessage("https://example.com/amp/story"))
+
+	var event AmputationEvent
+	ampBot.DB.Where(&AmputationEvent{MessageId: "300"}).Find(&event)
+	ampBot.interactionCreate(s, &discordgo.InteractionCreate{Interaction: &discordgo.Interaction{
+		Type:      discordgo.InteractionModalSubmit,
+		GuildID:   "200",
+		ChannelID: "400",
+		Member:    &discordgo.Member{User: &discordgo.User{ID: "600", Username: "Reporter"}},
+		Data: discordgo.ModalSubmitInteractionData{
+			CustomID: reportAction + ":" + event.UUID,
+			Components: []discordgo.MessageComponent{&discordgo.ActionsRow{Components: []discordgo.MessageComponent{
+				&discordgo.TextInput{CustomID: reportCommentID, Value: "wrong article"},
+			}}},
+		},
+	}})
+
+	var report AmputationReport
+	ampBot.DB.Where(&AmputationReport{AmputationEventUUID: event.UUID}).Find(&report)
+	if report.Status != pendingReport || report.Comment != "wrong article" {
+		t.Fatalf("expected a pending report, got %+v", report)
+	}
+
+	// The queue only lists the server's own reports, cut to fit Discord's
+	// limits for fields and messages
+	for i := range 6 {
+		ampBot.DB.Create(&AmputationReport{UUID: fmt.Sprintf("long-%v", i), ServerID: "200", ReporterUsername: "Reporter",
+			Comment: strings.Repeat("long comment ", 100)})
+	}
+	ampBot.DB.Create(&AmputationReport{UUID: "elsewhere", ServerID: "201", ReporterUsername: "Reporter"})
+	queue := testMessage(commandPrefix + " review")
+	queue.ID = "310"
+	queue.Author = &discordgo.User{ID: "700", Username: "Admin"}
+	sentBefore := len(s.sentMessages())
+	ampBot.messageCreate(s, queue)
+	var listed []string
+	for _, message := range s.sentMessages()[sentBefore:] {
+		length := len([]rune(message.Embeds[0].Title))
+		for _, field := range message.Embeds[0].Fields {
+			listed = append(listed, field.Name)
+			if len([]rune(field.Value)) > maxEmbedFieldLength {
+				t.Errorf("expected report %v to fit in a field, got %v characters", field.Name, len([]rune(field.Value)))
+			}
+			length += len([]rune(field.Name)) + len([]rune(field.Value))
+		}
+		if length > maxEmbedLength {
+			t.Errorf("expected the queue to be split to fit in messages, got %v characters", length)
+		}
+	}
+	if len(listed) != 7 || slices.Contains(listed, "elsewhere") {
+		t.Errorf("expected the server's 7 reports to be listed, got %v", listed)
+	}
+	ampBot.DB.Where("uuid LIKE ?", "long-%").Delete(&AmputationReport{})
+
+	// Only admins can review
+	review := testMessage(commandPrefix + " review accept " + report.UUID +
+		" https://example.com/amp/story https://example.com/story")
+	ampBot.messageCreate(s, review)
+	review.Author = &discordgo.User{ID: "700", Username: "Admin"}
+	ampBot.messageCreate(s, review)
+
+	ampBot.DB.Where(&AmputationReport{UUID: report.UUID}).Find(&report)
+	if report.Status != acceptedReport || report.ReviewedBy != "700" {
+		t.Fatalf("expected the report to be accepted by the admin, got %+v", report)
+	}
+	var override URLOverride
+	ampBot.DB.Where(&URLOverride{ReportUUID: report.UUID}).Find(&override)
+	if override.RequestURL != "https://example.com/amp/story" || len(override.RequestURLHash) != 64 {
+		t.Errorf("expected the override to be keyed by the url's hash, got %+v", override)
+	}
+
+	// The override now wins over the cache. It's posted in another channel
+	// so it isn't taken as a repeat of the first link.
+	m := testMessage("https://example.com/amp/story")
+	m.ID = "301"
+	m.ChannelID = "401"
+	ampBot.messageCreate(s, m)
+	sent := s.sentMessages()
+	if got := sent[len(sent)-1].Embeds[0].Description; got != "https://example.com/story" {
+		t.Errorf("expected the corrected link, got %v", got)
+	}
+}
+
+func TestRewriteRules(t *testing.T) {
+	ampBot, s := testInit(t)
+	fake := testAPI(t, ampBot)
+
+	for requestURL, want := range map[string]string{
+		"https://example.com/amp/story":                "https://example.com/story",
+		"https://example.com/story/amp?ref=1":          "https://example.com/story?ref=1",
+		"https://amp.example.com/story":                "https://example.com/story",
+		"https://example.com/story?outputType=amp":     "https://example.com/story",
+		"https://example.com/story?a=1&outputType=amp": "https://example.com/story?a=1",
+		"https://example.com/story.amp.html":           "https://example.com/story.html",
+	} {
+		if got, _, _ := ampBot.rewriteURL(context.Background(), requestURL, "200"); got != want {
+			t.Errorf("expected %v to be rewritten to %v, got %v", requestURL, want, got)
+		}
+	}
+
+	// Server rules run before the built-in ones
+	ampBot.messageCreate(s, testMessage(commandPrefix+` rule add news example.com ^(https://example\.com)/amp/(.*)$ ${1}/news/${2}`))
+	m := testMessage("https://www.example.com/amp/story https://example.com/amp/story")
+	m.ID = "301"
+	ampBot.messageCreate(s, m)
+
+	var amputations []Amputation
+	ampBot.DB.Where(&Amputation{Resolver: rewriteRuleResolverName}).Order("request_url").Find(&amputations)
+	if len(amputations) != 2 || amputations[0].RewriteRule != "news" || amputations[0].ResponseURL != "https://example.com/news/story" {
+		t.Fatalf("expected the server rule to be used, got %+v", amputations)
+	}
+	if amputations[1].RewriteRule != "amp_path_segment" {
+		t.Errorf("expected the built-in rule to be used for www, got %+v", amputations[1])
+	}
+	if len(fake.Requests()) != 0 {
+		t.Errorf("expected no api calls for rewritten urls, got %v", fake.Requests())
+	}
+
+	// Another server with its own rule for the same link gets its own
+	// result, and removing a rule stops it being used
+	s.addGuild(&discordgo.Guild{ID: "201", Name: "Other Guild"})
+	other := testMessage(commandPrefix + ` rule add sport example.com ^(https://example\.com)/amp/(.*)$ ${1}/sport/${2}`)
+	other.GuildID = "201"
+	ampBot.messageCreate(s, other)
+	for serverID, want := range map[string]string{"201": "https://example.com/sport/story", "200": "https://example.com/news/story"} {
+		m := testMessage("https://example.com/amp/story")
+		m.ID = "31" + serverID[2:]
+		m.ChannelID = "41" + serverID[2:]
+		m.GuildID = serverID
+		ampBot.messageCreate(s, m)
+		var amputation Amputation
+		ampBot.DB.Where(&Amputation{ServerID: serverID}).Order("created_at desc").Limit(1).Find(&amputation)
+		if amputation.ResponseURL != want {
+			t.Errorf("expected server %v to get %v, got %+v", serverID, want, amputation)
+		}
+	}
+	remove := testMessage(commandPrefix + " rule remove sport")
+	remove.GuildID = "201"
+	remove.ID = "320"
+	ampBot.messageCreate(s, remove)
+	m = testMessage("https://example.com/amp/story")
+	m.ID = "321"
+	m.ChannelID = "402"
+	m.GuildID = "201"
+	ampBot.messageCreate(s, m)
+	var latest Amputation
+	ampBot.DB.Where(&Amputation{ServerID: "201"}).Order("created_at desc").Limit(1).Find(&latest)
+	if latest.ResponseURL != "https://example.com/story" || latest.RewriteRule != "amp_path_segment" {
+		t.Errorf("expected the built-in rule once the server rule was removed, got %+v", latest)
+	}
+
+	// Rules can't send links to another site
+	add := testMessage(commandPrefix + ` rule add elsewhere * ^https://([a-z.]*)example\.org/amp/(.*)$ https://${1}example.net/${2}`)
+	add.ID = "302"
+	ampBot.messageCreate(s, add)
+	if rules := ampBot.rewriteRules(context.Background(), "200"); rules[1].Name != "elsewhere" {
+		t.Fatalf("expected the rule to be added, got %+v", rules)
+	}
+	if got, rule, _ := ampBot.rewriteURL(context.Background(), "https://example.org/amp/story", "200"); rule.Name == "elsewhere" {
+		t.Errorf("expected a rule not to rewrite to another site, got %v", got)
+	}
+	if got, rule, _ := ampBot.rewriteURL(context.Background(), "https://www.example.org/amp/story", "200"); rule.Name == "elsewhere" {
+		t.Errorf("expected a rule not to rewrite to another site, got %v", got)
+	}
+
+	// Only people who can manage the server can change its rules
+	s.permissions["500"] = discordgo.PermissionSendMessages
+	add = testMessage(commandPrefix + ` rule add other example.com ^(https://example\.com)/amp/(.*)$ ${1}/other/${2}`)
+	add.ID = "303"
+	ampBot.messageCreate(s, add)
+	var count int64
+	ampBot.DB.Model(&RewriteRule{}).Where(&RewriteRule{Name: "other"}).Count(&count)
+	if count != 0 {
+		t.Error("expected a rule added without Manage Server to be refused")
+	}
+}
+
+func TestAmpURLDetection(t *testing.T) {
+	for _, test := range []struct {
+		url         string
+		sensitivity string
+		want        bool
+	}{
+		{"https://example.com/ampersand-guide", highSensitivity, false},
+		{"https://example.com/sample-amp", mediumSensitivity, false},
+		{"https://example.com/sample-amp", highSensitivity, true},
+		{"https://example.com/amp/story", mediumSensitivity, true},
+		{"https://example.com/story?amp=1", mediumSensitivity, true},
+		{"https://example.com/story?outputType=amp", mediumSensitivity, true},
+		{"https://amp.example.com/story", mediumSensitivity, true},
+		{"https://amp.example.com/story", lowSensitivity, false},
+		{"https://example-com.cdn.ampproject.org/c/s/example.com/story", lowSensitivity, true},
+		{"https://www.google.com/amp/s/example.com/story", lowSensitivity, true},
+		{"https://example.com/story.amp.html", mediumSensitivity, true},
+		{"http://intranet/amp/x", highSensitivity, false},
+		{"http://intranet.local/amp/x", highSensitivity, false},
+		{"http://intranet./amp/x", highSensitivity, false},
+		{"http://93.184.215.14/amp/x", mediumSensitivity, true},
+	} {
+		if got := isAmpURL(test.url, test.sensitivity); got != test.want {
+			t.Errorf("isAmpURL(%v, %v) = %v, want %v", test.url, test.sensitivity, got, test.want)
+		}
+	}
+
+	if _, err := getDomainName("http://intranet/amp/x"); err == nil {
+		t.Error("expected an error for a url without a domain name")
+	}
+
+	// Links to hosts without a domain are ignored instead of crashing
+	ampBot, s := testInit(t)
+	ampBot.messageCreate(s, testMessage("http://intranet/amp/x"))
+	if len(s.sentMessages()) != 0 {
+		t.Errorf("expected no reply to a link without a domain, got %+v", s.sentMessages())
+	}
+}
+
+func TestExtractLinks(t *testing.T) {
+	content := "see https://a.com/amp/1 and `https://b.com/amp/2`\n" +
+		"```\nhttps://c.com/amp/3\n```\n" +
+		"<https://d.com/amp/4> [the story](https://e.com/amp/5) ||https://f.com/amp/6||"
+	want := []messageLink{
+		{URL: "https://a.com/amp/1"},
+		{URL: "https://d.com/amp/4", Suppressed: true},
+		{URL: "https://e.com/amp/5", Label: "the story"},
+		{URL: "https://f.com/amp/6", Spoiler: true},
+	}
+
+	links := extractLinks(content)
+	if len(links) != len(want) {
+		t.Fatalf("expected %v links, got %+v", len(want), links)
+	}
+	for i := range want {
+		links[i].position = 0
+		if links[i] != want[i] {
+			t.Errorf("expected %+v, got %+v", want[i], links[i])
+		}
+	}
+}
+
+func TestMessageCreateKeepsLinkFormatting(t *testing.T) {
+	ampBot, s := testInit(t)
+	ampBot.messageCreate(s, testMessage("<https://example.com/amp/a> [story](https://example.com/amp/b) ||https://example.com/amp/c||"))
+
+	sent := s.sentMessages()
+	want := "<https://example.com/a>\n[story](https://example.com/b)\n||https://example.com/c||"
+	if len(sent) != 1 || sent[0].Embeds[0].Description != want {
+		t.Fatalf("expected reply %q, got %+v", want, sent)
+	}
+}
+
+func TestMessageCreateScansEmbedsAndForwards(t *testing.T) {
+	ampBot, s := testInit(t)
+	m := testMessage("")
+	m.Author.Bot = true
+	m.Embeds = []*discordgo.MessageEmbed{{URL: "https://example.com/amp/feed"}}
+	m.MessageSnapshots = []discordgo.MessageSnapshot{{Message: &discordgo.Message{Content: "https://example.com/amp/forwarded"}}}
+	ampBot.messageCreate(s, m)
+
+	sent := s.sentMessages()
+	want := "https://example.com/feed\nhttps://example.com/forwarded"
+	if len(sent) != 1 || sent[0].Embeds[0].Description != want {
+		t.Fatalf("expected reply %q, got %+v", want, sent)
+	}
+
+	// With bots turned off, nothing more is sent
+	ampBot.messageCreate(s, testMessage(commandPrefix+" config bots off"))
+	m.ID = "301"
+	ampBot.messageCreate(s, m)
+	if len(s.sentMessages()) != 2 {
+		t.Errorf("expected messages from bots to be ignored, got %+v", s.sentMessages())
+	}
+}
+
+func TestScanCommand(t *testing.T) {
+	ampBot, s := testInit(t)
+	ampBot.Config.AdminIds = []string{"500"}
+	author := &discordgo.User{ID: "600", Username: "Poster"}
+	s.addHistory("401",
+		&discordgo.Message{ID: "3", ChannelID: "401", Author: author, Timestamp: time.Now(), Content: "https://example.com/amp/new"},
+		&discordgo.Message{ID: "2", ChannelID: "401", Author: author, Timestamp: time.Now(), Content: "no links here"},
+		&discordgo.Message{ID: "1", ChannelID: "401", Author: author, Timestamp: time.Now().AddDate(0, 0, -30), Content: "https://example.com/amp/old"},
+	)
+
+	// Channels in other servers can't be scanned
+	s.addChannel(&discordgo.Channel{ID: "451", GuildID: "201"})
+	ampBot.messageCreate(s, testMessage(commandPrefix+" scan <#451>"))
+	if ampBot.scans.running("451") || len(s.edits) != 0 || s.sentMessages()[0].Embeds[0].Title != "Unable to set scan" {
+		t.Fatalf("expected a channel in another server to be refused, got %+v", s.sentMessages())
+	}
+
+	s.addChannel(&discordgo.Channel{ID: "401", GuildID: "200"})
+	scan := testMessage(commandPrefix + " scan <#401> 7d")
+	scan.ID = "301"
+	ampBot.messageCreate(s, scan)
+	deadline := time.Now().Add(5 * time.Second)
+	for ampBot.scans.running("401") && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	s.mu.Lock()
+	last := s.edits[len(s.edits)-1]
+	s.mu.Unlock()
+	want := "Finished scanning <#401>\n2 messages checked, 1 AMP links found, 1 amputated"
+	if got := (*last.Embeds)[0].Description; got != want {
+		t.Errorf("expected summary %q, got %q", want, got)
+	}
+
+	var amputation Amputation
+	ampBot.DB.Where(&Amputation{RequestURL: "https://example.com/amp/new"}).Find(&amputation)
+	if amputation.ResponseURL != "https://example.com/new" {
+		t.Errorf("expected the link to be cached, got %+v", amputation)
+	}
+	if len(s.sentMessages()) != 2 {
+		t.Errorf("expected only the progress message to be sent, got %+v", s.sentMessages())
+	}
+}
+
+func TestCatchUp(t *testing.T) {
+	ampBot, s := testInit(t)
+	ampBot.messageCreate(s, testMessage("hello"))
+
+	// Checkpoints are only saved when they're flushed
+	var count int64
+	ampBot.DB.Model(&ChannelCheckpoint{}).Count(&count)
+	if count != 0 {
+		t.Errorf("expected no checkpoints before flushing, got %v", count)
+	}
+	ampBot.FlushCheckpoints(context.Background())
+	ampBot.DB.Model(&ChannelCheckpoint{}).Count(&count)
+	if count != 1 {
+		t.Errorf("expected a checkpoint after flushing, got %v", count)
+	}
+
+	author := &discordgo.User{ID: "600", Username: "Poster"}
+	s.addHistory("400",
+		&discordgo.Message{ID: "306", ChannelID: "400", Author: author, Timestamp: time.Now(), Content: "https://example.com/amp/live"},
+		&discordgo.Message{ID: "305", ChannelID: "400", Author: author, Timestamp: time.Now(), Content: "https://example.com/amp/handled"},
+		&discordgo.Message{ID: "304", ChannelID: "400", Author: author, Timestamp: time.Now(), Content: "https://example.com/amp/answered"},
+		&discordgo.Message{ID: "303", ChannelID: "400", Author: author, Timestamp: time.Now(), Content: "https://example.com/amp/new"},
+		&discordgo.Message{ID: "302", ChannelID: "400", Author: author, Timestamp: time.Now(), Content: "no links here"},
+		&discordgo.Message{ID: "301", ChannelID: "400", Author: author, Timestamp: time.Now().Add(-7 * time.Hour), Content: "https://example.com/amp/old"},
+		&discordgo.Message{ID: "300", ChannelID: "400", Author: author, Timestamp: time.Now(), Content: "hello"},
+	)
+
+	// Only the new link fits in the default look-back age. Messages from
+	// after Ready and ones already handled, before or after a restart, are
+	// skipped.
+	ampBot.recentLinks.claim("305", time.Now())
+	ampBot.DB.Create(&AmputationEvent{UUID: "answered", MessageId: "304", ChannelId: "400", ServerID: "200"})
+	sentBefore := len(s.sentMessages())
+	ampBot.catchUp(s, "306")
+	sent := s.sentMessages()
+	if len(sent) != sentBefore+1 {
+		t.Fatalf("expected one digest, got %+v", sent[sentBefore:])
+	}
+	want := "**Poster**: https://example.com/new"
+	if got := sent[len(sent)-1].Embeds[0].Description; got != want {
+		t.Errorf("expected digest %q, got %q", want, got)
+	}
+
+	var checkpoint ChannelCheckpoint
+	ampBot.DB.Where(&ChannelCheckpoint{ChannelID: "400"}).Find(&checkpoint)
+	if checkpoint.MessageID != "305" {
+		t.Errorf("expected the checkpoint to move to the last message, got %+v", checkpoint)
+	}
+
+	// Catching up again finds nothing new
+	ampBot.catchUp(s, "306")
+	if len(s.sentMessages()) != len(sent) {
+		t.Errorf("expected nothing to be sent, got %+v", s.sentMessages()[len(sent):])
+	}
+}
+
+func TestSnowflakeAfter(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want bool
+	}{
+		{"2", "1", true},
+		{"1", "2", false},
+		{"10", "9", true},
+		{"9", "10", false},
+		{"5", "5", false},
+	}
+	for _, test := range tests {
+		if got := snowflakeAfter(test.a, test.b); got != test.want {
+			t.Errorf("snowflakeAfter(%v, %v) = %v, want %v", test.a, test.b, got, test.want)
+		}
+	}
+}
+
+func TestSnowflakeAt(t *testing.T) {
+	if got := snowflakeAt(time.UnixMilli(1462015105796)); got != "175928847298985984" {
+		t.Errorf("expected the smallest ID for the time, got %v", got)
+	}
+}
+
+func TestReplaceMode(t *testing.T) {
+	ampBot, s := testInit(t)
+	ampBot.messageCreate(s, testMessage(commandPrefix+" config mode replace"))
+	sentBefore := len(s.sentMessages())
+
+	m := testMessage("look https://example.com/amp/story")
+	m.Timestamp = time.Now()
+	m.Member = &discordgo.Member{Nick: "Nick"}
+	m.MessageReference = &discordgo.MessageReference{ChannelID: "400", MessageID: "299"}
+	ampBot.messageCreate(s, m)
+
+	if len(s.executed) != 1 {
+		t.Fatalf("expected the message to be reposted, got %+v", s.executed)
+	}
+	repost := s.executed[0]
+	want := "-# ↪ Replying to https://discord.com/channels/200/400/299\nlook https://example.com/story"
+	if repost.Content != want || repost.Username != "Nick" {
+		t.Errorf("expected repost %q from Nick, got %q from %v", want, repost.Content, repost.Username)
+	}
+	if len(s.deleted) != 1 || s.deleted[0] != "300" {
+		t.Errorf("expected the original to be deleted, got %v", s.deleted)
+	}
+	if len(s.sentMessages()) != sentBefore {
+		t.Errorf("expected no reply, got %+v", s.sentMessages()[sentBefore:])
+	}
+
+	// The repost itself is left alone, even if it still had an AMP link
+	ampBot.messageCreate(s, &discordgo.MessageCreate{Message: &discordgo.Message{
+		ID:        "301",
+		ChannelID: "400",
+		GuildID:   "200",
+		WebhookID: s.webhooks[0].ID,
+		Content:   "https://example.com/amp/other",
+		Author:    &discordgo.User{ID: s.webhooks[0].ID, Username: "Nick", Bot: true},
+	}})
+	if len(s.executed) != 1 || len(s.sentMessages()) != sentBefore {
+		t.Errorf("expected the repost to be ignored")
+	}
+
+	// Without permission to manage webhooks the bot replies instead
+	s.permissions["100"] = discordgo.PermissionManageMessages
+	m = testMessage("https://example.com/amp/another")
+	m.ID = "302"
+	m.Timestamp = time.Now()
+	ampBot.messageCreate(s, m)
+	if len(s.executed) != 1 || len(s.sentMessages()) != sentBefore+1 {
+		t.Errorf("expected a reply instead of a repost, got %v reposts and %v replies",
+			len(s.executed), len(s.sentMessages())-sentBefore)
+	}
+}
+
+func TestAttachmentDownloadsAreCapped(t *testing.T) {
+	// Attachments bigger than Discord said they were aren't reposted
+	files := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(make([]byte, maxReplaceAttachmentSize/2+1))
+	}))
+	defer files.Close()
+	attachments := []*discordgo.MessageAttachment{
+		{Filename: "a.png", URL: files.URL + "/a.png", Size: 10},
+		{Filename: "b.png", URL: files.URL + "/b.png", Size: 10},
+	}
+	if _, err := downloadAttachments(context.Background(), attachments); err == nil {
+		t.Error("expected attachments over the size limit to be refused")
+	}
+	if downloaded, err := downloadAttachments(context.Background(), attachments[:1]); err != nil || len(downloaded) != 1 {
+		t.Errorf("expected an attachment under the limit to be downloaded, got %v", err)
+	}
+}
+
+func TestSuppressEmbeds(t *testing.T) {
+	ampBot, s := testInit(t)
+	ampBot.messageCreate(s, testMessage(commandPrefix+" config suppress on"))
+	ampBot.messageCreate(s, testMessage(commandPrefix+" config embed off"))
+	ampBot.messageCreate(s, testMessage(commandPrefix+" config previews off"))
+
+	ampBot.messageCreate(s, testMessage("https://example.com/amp/story"))
+	sent := s.sentMessages()
+	if reply := sent[len(sent)-1]; reply.Flags&discordgo.MessageFlagsSuppressEmbeds == 0 {
+		t.Errorf("expected the reply's previews to be hidden, got flags %v", reply.Flags)
+	}
+	if len(s.edits) != 1 || s.edits[0].ID != "300" || s.edits[0].Flags&discordgo.MessageFlagsSuppressEmbeds == 0 {
+		t.Errorf("expected the original's previews to be hidden, got %+v", s.edits)
+	}
+
+	// Without Manage Messages the original is left alone
+	s.permissions["100"] = discordgo.PermissionSendMessages
+	m := testMessage("https://example.com/amp/other")
+	m.ID = "301"
+	ampBot.messageCreate(s, m)
+	if len(s.edits) != 1 {
+		t.Errorf("expected no more edits, got %+v", s.edits)
+	}
+}
+
+func TestAuthorAndBotPermissions(t *testing.T) {
+	ampBot, s := testInit(t)
+
+	// An author who manages the server can change settings even though
+	// the bot can't act on them
+	s.permissions["100"] = discordgo.PermissionSendMessages
+	ampBot.messageCreate(s, testMessage(commandPrefix+" config suppress on"))
+	if !ampBot.getServerConfig("200").SuppressOriginalEmbeds {
+		t.Fatal("expected the manager to change the setting")
+	}
+	m := testMessage("https://example.com/amp/story")
+	m.ID = "301"
+	ampBot.messageCreate(s, m)
+	if len(s.edits) != 0 {
+		t.Errorf("expected the bot not to hide previews without Manage Messages, got %+v", s.edits)
+	}
+
+	// The bot having every permission doesn't let the author change them
+	s.permissions["100"] = discordgo.PermissionAll
+	s.permissions["500"] = discordgo.PermissionSendMessages
+	off := testMessage(commandPrefix + " config suppress off")
+	off.ID = "302"
+	ampBot.messageCreate(s, off)
+	if !ampBot.getServerConfig("200").SuppressOriginalEmbeds {
+		t.Error("expected an author without Manage Server to be refused")
+	}
+}
+
+func TestLogChannel(t *testing.T) {
+	ampBot, s := testInit(t)
+	ampBot.Config.APIRetries = -1
+	fake := testAPI(t, ampBot)
+	fake.Fallback(&fakeapi.Response{Status: http.StatusTooManyRequests, Body: "slow down"})
+
+	// Channels in other servers are refused
+	s.addChannel(&discordgo.Channel{ID: "451", GuildID: "201"})
+	ampBot.messageCreate(s, testMessage(commandPrefix+" config logchannel <#451>"))
+	if channel := ampBot.getServerConfig("200").LogChannel; channel != "" {
+		t.Fatalf("expected a channel in another server to be refused, got %v", channel)
+	}
+
+	s.addChannel(&discordgo.Channel{ID: "450", GuildID: "200"})
+	ampBot.messageCreate(s, testMessage(commandPrefix+" config logchannel <#450>"))
+	ampBot.messageCreate(s, testMessage(commandPrefix+" config layout rich"))
+	ampBot.messageCreate(s, testMessage(commandPrefix+" config logratelimits off"))
+	ampBot.messageCreate(s, testMessage(ampBot.Config.AmputatorAPIURL+"/story?amp=1"))
+
+	var logged []string
+	for _, m := range s.sentMessages() {
+		if m.ChannelID == "450" {
+			logged = append(logged, m.Embeds[0].Title+": "+m.Embeds[0].Description)
+		}
+	}
+	if len(logged) != 4 {
+		t.Fatalf("expected three config changes and a failure to be logged, got %q", logged)
+	}
+	want := "Config Changed: <@500> changed:\nReply layout (compact or rich): `compact` → `rich`"
+	if logged[1] != want {
+		t.Errorf("expected %q, got %q", want, logged[1])
+	}
+	if !strings.HasPrefix(logged[3], "Amputation Failed: Unable to amputate links in https://discord.com/channels/200/400/300") ||
+		!strings.Contains(logged[3], "429") {
+		t.Errorf("expected the failure and its reason to be logged, got %q", logged[3])
+	}
+
+	// Log channels set before they were checked aren't posted to either
+	ampBot.DB.Model(&ServerConfig{}).Where(&ServerConfig{DiscordId: "200"}).Update("LogChannel", "451")
+	ampBot.logToServer(context.Background(), s, "200", logConfigChanges, "test", log.WithField("test", t.Name()))
+	for _, m := range s.sentMessages() {
+		if m.ChannelID == "451" {
+			t.Errorf("expected nothing to be posted in another server's channel, got %+v", m.Embeds[0])
+		}
+	}
+}
+
+func TestConfigHistory(t *testing.T) {
+	ampBot, s := testInit(t)
+	ampBot.Config.AdminAPIToken = "secret"
+	ampBot.messageCreate(s, testMessage(commandPrefix+" config layout rich"))
+
+	var changes []ConfigChange
+	ampBot.DB.Find(&changes)
+	if len(changes) != 1 {
+		t.Fatalf("expected one change to be recorded, got %+v", changes)
+	}
+	change := changes[0]
+	if change.Setting != "ReplyLayout" || change.OldValue != compactLayout || change.NewValue != richLayout ||
+		change.UserID != "500" || change.Source != configSourceText {
+		t.Errorf("unexpected change %+v", change)
+	}
+
+	ampBot.messageCreate(s, testMessage(commandPrefix+" config history"))
+	sent := s.sentMessages()
+	if fields := sent[len(sent)-1].Embeds[0].Fields; len(fields) != 1 || fields[0].Name != change.UUID {
+		t.Errorf("expected the change in the history, got %+v", fields)
+	}
+
+	// The admin API needs the token
+	api := ampBot.healthAPI()
+	recorder := httptest.NewRecorder()
+	api.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/api/servers/200/config/history", nil))
+	if recorder.Code != http.StatusUnauthorized {
+		t.Errorf("expected a request without the token to be rejected, got %v", recorder.Code)
+	}
+
+	request := httptest.NewRequest(http.MethodPost, "/api/servers/200/config/revert/"+change.UUID, nil)
+	request.Header.Set("Authorization", "Bearer secret")
+	recorder = httptest.NewRecorder()
+	api.ServeHTTP(recorder, request)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("expected the revert to succeed, got %v: %v", recorder.Code, recorder.Body)
+	}
+	if layout := ampBot.getServerConfig("200").ReplyLayout; layout != compactLayout {
+		t.Errorf("expected the layout to be reverted, got %v", layout)
+	}
+
+	var revert ConfigChange
+	ampBot.DB.Where(&ConfigChange{Source: configSourceAPI}).Find(&revert)
+	if revert.OldValue != richLayout || revert.NewValue != compactLayout {
+		t.Errorf("expected the revert to be recorded, got %+v", revert)
+	}
+}
+
+func TestConfigSettings(t *testing.T) {
+	ampBot, s := testInit(t)
+	ampBot.Config.AdminAPIToken = "secret"
+
+	// Typos are rejected instead of turning settings off
+	ampBot.messageCreate(s, testMessage(commandPrefix+" config bots onn"))
+	sent := s.sentMessages()
+	if title := sent[len(sent)-1].Embeds[0].Title; title != "Unable to change config" {
+		t.Errorf("expected the typo to be rejected, got %v", title)
+	}
+	if !ampBot.getServerConfig("200").ProcessBots {
+		t.Error("expected bots to still be processed")
+	}
+	ampBot.messageCreate(s, testMessage(commandPrefix+" config bots no"))
+	if ampBot.getServerConfig("200").ProcessBots {
+		t.Error("expected bots to no longer be processed")
+	}
+
+	for value, want := range map[string]int{"0": 3, "11": 3, "five": 3, "5": 5} {
+		ampBot.messageCreate(s, testMessage(commandPrefix+" config maxdepth "+value))
+		if maxDepth := ampBot.getServerConfig("200").MaxDepth; maxDepth != want {
+			t.Errorf("expected max depth %v after setting it to %v, got %v", want, value, maxDepth)
+		}
+		ampBot.messageCreate(s, testMessage(commandPrefix+" config reset maxdepth"))
+	}
+
+	ampBot.messageCreate(s, testMessage(commandPrefix+" config help maxdepth"))
+	sent = s.sentMessages()
+	if embed := sent[len(sent)-1].Embeds[0]; embed.Title != "maxdepth" || embed.Fields[0].Value != "a number from 1 to 10" {
+		t.Errorf("expected help for max depth, got %+v", embed)
+	}
+
+	ampBot.messageCreate(s, testMessage(commandPrefix+" config reset"))
+	if sc := ampBot.getServerConfig("200"); !sc.ProcessBots || sc.MaxDepth != 3 {
+		t.Errorf("expected every setting to be reset, got %+v", sc)
+	}
+
+	// Only people who can manage the server can change settings, but
+	// anyone can look at them
+	s.permissions["500"] = discordgo.PermissionSendMessages
+	for _, command := range []string{"config mode replace", "config reset", "config revert latest"} {
+		ampBot.messageCreate(s, testMessage(commandPrefix+" "+command))
+		sent = s.sentMessages()
+		if description := sent[len(sent)-1].Embeds[0].Description; !strings.HasPrefix(description, errNotServerManager.Error()) {
+			t.Errorf("expected %v to be refused, got %v", command, description)
+		}
+	}
+	if mode := ampBot.getServerConfig("200").ReplyMode; mode != replyMode {
+		t.Errorf("expected the mode not to change, got %v", mode)
+	}
+	ampBot.messageCreate(s, testMessage(commandPrefix+" config"))
+	sent = s.sentMessages()
+	if title := sent[len(sent)-1].Embeds[0].Title; title != "Amputator Config" {
+		t.Errorf("expected the config to be shown, got %v", title)
+	}
+	s.permissions["500"] = discordgo.PermissionAll
+
+	// The admin API goes through the same validation
+	api := ampBot.healthAPI()
+	for body, want := range map[string]int{`{"value": "wide"}`: http.StatusBadRequest, `{"value": "rich"}`: http.StatusOK} {
+		request := httptest.NewRequest(http.MethodPut, "/api/servers/200/config/layout", strings.NewReader(body))
+		request.Header.Set("Authorization", "Bearer secret")
+		recorder := httptest.NewRecorder()
+		api.ServeHTTP(recorder, request)
+		if recorder.Code != want {
+			t.Errorf("expected %v setting layout with %v, got %v: %v", want, body, recorder.Code, recorder.Body)
+		}
+	}
+	if layout := ampBot.getServerConfig("200").ReplyLayout; layout != richLayout {
+		t.Errorf("expected the layout to be set through the API, got %v", layout)
+	}
+
+	// So does the slash command
+	ampBot.interactionCreate(s, &discordgo.InteractionCreate{Interaction: &discordgo.Interaction{
+		Type:    discordgo.InteractionApplicationCommand,
+		GuildID: "200",
+		Member:  &discordgo.Member{User: &discordgo.User{ID: "500", Username: "User Name"}},
+		Data: discordgo.ApplicationCommandInteractionData{
+			Name: configCommand,
+			Options: []*discordgo.ApplicationCommandInteractionDataOption{{
+				Name: "set",
+				Type: discordgo.ApplicationCommandOptionSubCommand,
+				Options: []*discordgo.ApplicationCommandInteractionDataOption{
+					{Name: "setting", Type: discordgo.ApplicationCommandOptionString, Value: "guess"},
+					{Name: "value", Type: discordgo.ApplicationCommandOptionString, Value: "off"},
+				},
+			}},
+		},
+	}})
+	if ampBot.getServerConfig("200").GuessAndCheck {
+		t.Error("expected guessing to be turned off by the slash command")
+	}
+	if len(s.responses) != 1 || s.responses[0].Data.Flags != discordgo.MessageFlagsEphemeral {
+		t.Errorf("expected an ephemeral response, got %+v", s.responses)
+	}
+
+	var change ConfigChange
+	ampBot.DB.Where(&ConfigChange{Source: configSourceSlash}).Find(&change)
+	if change.Setting != "GuessAndCheck" || change.UserID != "500" {
+		t.Errorf("expected the slash command change to be recorded, got %+v", change)
+	}
+}
+
+func TestSettingsAutocompleteAndPages(t *testing.T) {
+	ampBot, s := testInit(t)
+	ampBot.interactionCreate(s, &discordgo.InteractionCreate{Interaction: &discordgo.Interaction{
+		Type:    discordgo.InteractionApplicationCommandAutocomplete,
+		GuildID: "200",
+		Member:  &discordgo.Member{User: &discordgo.User{ID: "500", Username: "User Name"}},
+		Data: discordgo.ApplicationCommandInteractionData{
+			Name: configCommand,
+			Options: []*discordgo.ApplicationCommandInteractionDataOption{{
+				Name: "help",
+				Type: discordgo.ApplicationCommandOptionSubCommand,
+				Options: []*discordgo.ApplicationCommandInteractionDataOption{
+					{Name: "setting", Type: discordgo.ApplicationCommandOptionString, Value: "LIMIT", Focused: true},
+				},
+			}},
+		},
+	}})
+	if len(s.responses) != 1 || s.responses[0].Type != discordgo.InteractionApplicationCommandAutocompleteResult {
+		t.Fatalf("expected suggestions, got %+v", s.responses)
+	}
+	var suggested []string
+	for _, choice := range s.responses[0].Data.Choices {
+		suggested = append(suggested, choice.Name)
+	}
+	if want := []string{"logratelimits", "ratelimit", "userlimit", "channellimit", "serverlimit"}; !slices.Equal(suggested, want) {
+		t.Errorf("expected %v to be suggested, got %v", want, suggested)
+	}
+
+	// Settings past Discord's 25 fields per embed go in another embed
+	saved := configSettings
+	t.Cleanup(func() { configSettings = saved })
+	configSettings = append(slices.Clone(saved), configSetting{Name: "extra", Field: "ReplyMode", Kind: choiceSetting,
+		Choices: []string{replyMode, replaceMode}})
+	ampBot.messageCreate(s, testMessage(commandPrefix+" config"))
+	sent := s.sentMessages()
+	embeds := sent[len(sent)-1].Embeds
+	if len(embeds) != 2 || len(embeds[0].Fields) != maxEmbedFields || len(embeds[1].Fields) != len(configSettings)-maxEmbedFields {
+		t.Errorf("expected the settings to be split across two embeds, got %+v", embeds)
+	}
+}
+
+func TestCommandPrefix(t *testing.T) {
+	ampBot, s := testInit(t)
+	ampBot.messageCreate(s, testMessage(commandPrefix+" config prefix ?a"))
+	if prefix := ampBot.getServerConfig("200").CommandPrefix; prefix != "?a" {
+		t.Fatalf("expected the prefix to be changed, got %v", prefix)
+	}
+
+	sent := len(s.sentMessages())
+	ampBot.messageCreate(s, testMessage(commandPrefix+" stats"))
+	if len(s.sentMessages()) != sent {
+		t.Error("expected the old prefix to be ignored")
+	}
+
+	for _, content := range []string{"?a stats", "?a\tstats", "<@100> stats", "<@!100>  stats"} {
+		sent := len(s.sentMessages())
+		ampBot.messageCreate(s, testMessage(content))
+		messages := s.sentMessages()
+		if len(messages) != sent+1 || messages[len(messages)-1].Embeds[0].Title != "Amputation Stats" {
+			t.Errorf("expected %q to show stats", content)
+		}
+	}
+
+	// The prefix has to be followed by a space or the end of the message
+	sent = len(s.sentMessages())
+	for _, content := range []string{"?astats", "?amazing stats", "<@100>stats"} {
+		ampBot.messageCreate(s, testMessage(content))
+	}
+	if len(s.sentMessages()) != sent {
+		t.Errorf("expected words starting with the prefix not to be commands, got %+v", s.sentMessages()[sent:])
+	}
+
+	ampBot.messageCreate(s, testMessage("?a config prefix two words"))
+	ampBot.messageCreate(s, testMessage("<@100> config prefix waytoolongprefix"))
+	if prefix := ampBot.getServerConfig("200").CommandPrefix; prefix != "?a" {
+		t.Errorf("expected invalid prefixes to be rejected, got %v", prefix)
+	}
+
+	// Mentions work even if the prefix is forgotten
+	ampBot.messageCreate(s, testMessage("<@100> config reset prefix"))
+	if prefix := ampBot.serverPrefix("200"); prefix != commandPrefix {
+		t.Errorf("expected the prefix to be reset, got %v", prefix)
+	}
+}
+
+func TestThrottle(t *testing.T) {
+	throttle := &throttle{buckets: map[string]*tokenBucket{}}
+	now := time.Now()
+	limits := []throttleLimit{{Scope: userScope, Key: "user", Limit: 2}, {Scope: channelScope, Key: "channel", Limit: 3}}
+	for i, want := range []string{"", "", userScope} {
+		if scope := throttle.allow(limits, now); scope != want {
+			t.Errorf("expected message %v to be limited by %q, got %q", i, want, scope)
+		}
+	}
+
+	// Another user has their own bucket but shares the channel's
+	other := []throttleLimit{{Scope: userScope, Key: "other", Limit: 2}, {Scope: channelScope, Key: "channel", Limit: 3}}
+	for i, want := range []string{"", channelScope} {
+		if scope := throttle.allow(other, now); scope != want {
+			t.Errorf("expected other message %v to be limited by %q, got %q", i, want, scope)
+		}
+	}
+
+	// Half the interval earns back half the limit
+	if scope := throttle.allow(limits, now.Add(throttleInterval/2)); scope != "" {
+		t.Errorf("expected the buckets to refill, got %q", scope)
+	}
+}
+
+func TestRateLimits(t *testing.T) {
+	ampBot, s := testInit(t)
+	ampBot.messageCreate(s, testMessage(commandPrefix+" config userlimit 1"))
+
+	ampBot.messageCreate(s, testMessage("https://example.com/amp/story"))
+	sent := len(s.sentMessages())
+	m := testMessage("https://example.com/amp/again")
+	m.ID = "301"
+	ampBot.messageCreate(s, m)
+	if len(s.sentMessages()) != sent {
+		t.Error("expected no reply over the limit")
+	}
+	if len(s.reactions) != 1 || s.reactions[0].Emoji != rateLimitedEmoji {
+		t.Errorf("expected a reaction over the limit, got %+v", s.reactions)
+	}
+
+	// Commands are limited too, except for people who can manage the server
+	s.permissions["500"] = discordgo.PermissionSendMessages
+	ampBot.messageCreate(s, testMessage(commandPrefix+" stats"))
+	if len(s.sentMessages()) != sent {
+		t.Error("expected no reply to a command over the limit")
+	}
+	s.permissions["500"] = discordgo.PermissionAll
+	ampBot.messageCreate(s, testMessage(commandPrefix+" stats"))
+	messages := s.sentMessages()
+	if len(messages) != sent+1 {
+		t.Fatal("expected a reply to a command from someone who manages the server")
+	}
+	rateLimited := ""
+	for _, field := range messages[len(messages)-1].Embeds[0].Fields {
+		if field.Name == "Messages Rate Limited" {
+			rateLimited = field.Value
+		}
+	}
+	if rateLimited != "2" {
+		t.Errorf("expected the rate limited messages to be counted, got %q", rateLimited)
+	}
+
+	// Batched messages get one combined reply
+	ampBot.messageCreate(s, testMessage(commandPrefix+" config ratelimit batch"))
+	sent = len(s.sentMessages())
+	for i, path := range []string{"first", "second"} {
+		m := testMessage("https://example.com/amp/" + path)
+		m.ID = fmt.Sprintf("%v", 302+i)
+		ampBot.messageCreate(s, m)
+	}
+	deadline := time.Now().Add(time.Second)
+	for len(s.sentMessages()) == sent && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond * 10)
+	}
+	messages = s.sentMessages()
+	if len(messages) != sent+1 {
+		t.Fatalf("expected one combined reply, got %v", len(messages)-sent)
+	}
+	if embed := messages[len(messages)-1].Embeds[0]; strings.Count(embed.Description, "\n") != 1 {
+		t.Errorf("expected both links in the reply, got %q", embed.Description)
+	}
+}
+
+func TestNormalizeLinkURL(t *testing.T) {
+	for rawURL, want := range map[string]string{
+		"https://www.Example.com/amp/story/":                "example.com/amp/story",
+		"http://example.com/amp/story#comments":             "example.com/amp/story",
+		"https://example.com/story?b=2&utm_source=x&a=1":    "example.com/story?a=1&b=2",
+		"https://example.com/story?fbclid=abc&UTM_MEDIUM=y": "example.com/story",
+		"https://example.com/story?amp=1":                   "example.com/story?amp=1",
+	} {
+		if got := normalizeLinkURL(rawURL); got != want {
+			t.Errorf("expected %v to normalize to %v, got %v", rawURL, want, got)
+		}
+	}
+}
+
+func TestDuplicateLinks(t *testing.T) {
+	ampBot, s := testInit(t)
+	ampBot.messageCreate(s, testMessage("https://example.com/amp/story"))
+	first := s.sentMessages()[0]
+
+	// Discord delivering the same message again is ignored
+	ampBot.messageCreate(s, testMessage("https://example.com/amp/story"))
+	if len(s.sentMessages()) != 1 {
+		t.Fatalf("expected one reply to a message delivered twice, got %v", len(s.sentMessages()))
+	}
+
+	// The same link in another message links back to the first reply
+	m := testMessage("https://www.example.com/amp/story/?utm_source=feed")
+	m.ID = "301"
+	ampBot.messageCreate(s, m)
+	sent := s.sentMessages()
+	want := "https://discord.com/channels/200/400/" + first.ID
+	if len(sent) != 2 || !strings.Contains(sent[1].Embeds[0].Description, want) {
+		t.Fatalf("expected a link to the first reply, got %+v", sent[1].Embeds[0])
+	}
+	var events int64
+	ampBot.DB.Model(&AmputationEvent{}).Count(&events)
+	if events != 1 {
+		t.Errorf("expected the repeat not to be amputated again, got %v events", events)
+	}
+
+	// New links in the same message are still amputated
+	m = testMessage("https://example.com/amp/story https://example.com/amp/other")
+	m.ID = "302"
+	ampBot.messageCreate(s, m)
+	sent = s.sentMessages()
+	if len(sent) != 3 || sent[2].Embeds[0].Description != "https://example.com/other" {
+		t.Errorf("expected only the new link to be amputated, got %+v", sent[2].Embeds[0])
+	}
+
+	ampBot.messageCreate(s, testMessage(commandPrefix+" config duplicates react"))
+	sent = s.sentMessages()
+	m = testMessage("https://example.com/amp/other")
+	m.ID = "303"
+	ampBot.messageCreate(s, m)
+	if len(s.sentMessages()) != len(sent) || len(s.reactions) != 1 || s.reactions[0].Emoji != duplicateEmoji {
+		t.Errorf("expected a reaction to the repeat, got %+v", s.reactions)
+	}
+
+	// Links are amputated again in other channels, or after the window
+	m = testMessage("https://example.com/amp/other")
+	m.ID = "304"
+	m.ChannelID = "401"
+	ampBot.messageCreate(s, m)
+	if len(s.sentMessages()) != len(sent)+1 {
+		t.Error("expected the link to be amputated in another channel")
+	}
+	ampBot.recentLinks.remember("400", []string{normalizeLinkURL("https://example.com/amp/story"),
+		normalizeLinkURL("https://example.com/story")}, want, time.Now().Add(-time.Duration(defaultDuplicateWindow+1)*time.Minute))
+	m = testMessage("https://example.com/amp/story")
+	m.ID = "305"
+	ampBot.messageCreate(s, m)
+	if len(s.sentMessages()) != len(sent)+2 {
+		t.Error("expected the link to be amputated again after the window")
+	}
+
+	// A different link leading to the same page is a repeat too
+	m = testMessage("https://amp.example.com/story")
+	m.ID = "306"
+	ampBot.messageCreate(s, m)
+	if len(s.sentMessages()) != len(sent)+2 || len(s.reactions) != 2 {
+		t.Errorf("expected a reaction to a link leading to the same page, got %v replies", len(s.sentMessages()))
+	}
+
+	// Scans and catching up don't handle messages that were already handled
+	links := messageLinks(m.Message)
+	logger := messageLogger(m.Message)
+	if err := ampBot.handleMessageWithAmpUrls(context.Background(), s, m, links, logger); err != nil {
+		t.Error(err)
+	}
+	if len(s.sentMessages()) != len(sent)+2 || len(s.reactions) != 2 {
+		t.Error("expected a message that was already handled not to be answered again")
+	}
+	m = testMessage("https://example.com/amp/new")
+	m.ID = "307"
+	if len(ampBot.recordAmputations(context.Background(), ServerConfig{}, m.Message, messageLinks(m.Message), logger)) != 1 {
+		t.Error("expected the links in a new message to be recorded")
+	}
+	if ampBot.recordAmputations(context.Background(), ServerConfig{}, m.Message, messageLinks(m.Message), logger) != nil {
+		t.Error("expected a message that was already recorded not to be recorded again")
+	}
 }
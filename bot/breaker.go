@@ -0,0 +1,97 @@
+package bot
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	breakerClosed   string = "closed"
+	breakerOpen     string = "open"
+	breakerHalfOpen string = "half-open"
+)
+
+// circuitBreaker stops calls to an upstream that keeps failing. After
+// threshold consecutive failures it opens and rejects calls for the
+// cooldown, then lets a single trial call through. A successful trial
+// closes it again; a failed one reopens it.
+type circuitBreaker struct {
+	mu        sync.Mutex
+	threshold int
+	cooldown  time.Duration
+	failures  int
+	state     string
+	openedAt  time.Time
+	trialOut  bool
+}
+
+func newCircuitBreaker(threshold int, cooldown time.Duration) *circuitBreaker {
+	return &circuitBreaker{
+		threshold: threshold,
+		cooldown:  cooldown,
+		state:     breakerClosed,
+	}
+}
+
+// Allow reports whether a call should be attempted right now.
+func (b *circuitBreaker) Allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case breakerOpen:
+		if time.Since(b.openedAt) < b.cooldown {
+			return false
+		}
+		b.state = breakerHalfOpen
+		b.trialOut = true
+		return true
+	case breakerHalfOpen:
+		// Only one trial call at a time
+		if b.trialOut {
+			return false
+		}
+		b.trialOut = true
+		return true
+	}
+	return true
+}
+
+// Success records a successful call and closes the breaker.
+func (b *circuitBreaker) Success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures = 0
+	b.trialOut = false
+	b.state = breakerClosed
+}
+
+// Failure records a failed call, opening the breaker if the failure
+// threshold has been reached or a trial call failed.
+func (b *circuitBreaker) Failure() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures++
+	b.trialOut = false
+	if b.state == breakerHalfOpen || b.failures >= b.threshold {
+		b.state = breakerOpen
+		b.openedAt = time.Now()
+	}
+}
+
+// State returns closed, open or half-open.
+func (b *circuitBreaker) State() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == breakerOpen && time.Since(b.openedAt) >= b.cooldown {
+		return breakerHalfOpen
+	}
+	return b.state
+}
+
+// Failures returns the number of consecutive failures recorded.
+func (b *circuitBreaker) Failures() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.failures
+}
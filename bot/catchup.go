@@ -0,0 +1,291 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+const (
+	// maxDigestLength keeps catch up digests within Discord's limit for
+	// plain text messages, which is lower than the one for embeds.
+	maxDigestLength int = 1900
+
+	// discordEpoch is the first millisecond of 2015, which Discord IDs
+	// count from.
+	discordEpoch int64 = 1420070400000
+
+	// checkpointFlushInterval is how often checkpoints kept in memory are
+	// saved to the database.
+	checkpointFlushInterval time.Duration = time.Minute
+)
+
+// A ChannelCheckpoint is the last message the bot saw in a channel, so it
+// can catch up on the messages it missed while it was offline.
+type ChannelCheckpoint struct {
+	ChannelID string `gorm:"primaryKey"`
+	ServerID  string
+	MessageID string
+	UpdatedAt time.Time
+}
+
+// A checkpointCache keeps the last message seen in each channel in memory
+// until the checkpoints are flushed to the database, so busy channels
+// don't cost a write for every message.
+type checkpointCache struct {
+	mu      sync.Mutex
+	pending map[string]ChannelCheckpoint
+}
+
+// update records a message as the last one seen in its channel, unless a
+// newer one is already pending.
+func (c *checkpointCache) update(m *discordgo.Message, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if pending, ok := c.pending[m.ChannelID]; ok && !snowflakeAfter(m.ID, pending.MessageID) {
+		return
+	}
+	c.pending[m.ChannelID] = ChannelCheckpoint{
+		ChannelID: m.ChannelID,
+		ServerID:  m.GuildID,
+		MessageID: m.ID,
+		UpdatedAt: now,
+	}
+}
+
+// take returns the pending checkpoints and forgets them.
+func (c *checkpointCache) take() map[string]ChannelCheckpoint {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	pending := c.pending
+	c.pending = map[string]ChannelCheckpoint{}
+	return pending
+}
+
+func newCheckpointCache() *checkpointCache {
+	return &checkpointCache{pending: map[string]ChannelCheckpoint{}}
+}
+
+// snowflakeAt returns the smallest Discord ID for a time, so any message
+// with a lower ID was posted before it.
+func snowflakeAt(t time.Time) string {
+	return strconv.FormatInt((t.UnixMilli()-discordEpoch)<<22, 10)
+}
+
+// snowflakeAfter reports whether Discord ID a is newer than b. IDs grow
+// over time, so a longer ID is always newer.
+func snowflakeAfter(a, b string) bool {
+	if len(a) != len(b) {
+		return len(a) > len(b)
+	}
+	return a > b
+}
+
+// saveCheckpoint records a message as the last one handled in its
+// channel, unless a newer one was already handled. It's kept in memory
+// until the checkpoints are flushed. DMs aren't caught up on.
+func (bot *AmputatorBot) saveCheckpoint(m *discordgo.Message) {
+	if m.GuildID == "" {
+		return
+	}
+	bot.checkpoints.update(m, time.Now())
+}
+
+// FlushCheckpoints saves the checkpoints kept in memory to the database,
+// leaving alone any channel that already has a newer one saved.
+func (bot *AmputatorBot) FlushCheckpoints(ctx context.Context) {
+	pending := bot.checkpoints.take()
+	if len(pending) == 0 {
+		return
+	}
+	channelIDs := make([]string, 0, len(pending))
+	for channelID := range pending {
+		channelIDs = append(channelIDs, channelID)
+	}
+	var saved []ChannelCheckpoint
+	bot.DB.WithContext(ctx).Where("channel_id IN ?", channelIDs).Find(&saved)
+	for _, checkpoint := range saved {
+		if !snowflakeAfter(pending[checkpoint.ChannelID].MessageID, checkpoint.MessageID) {
+			delete(pending, checkpoint.ChannelID)
+		}
+	}
+	for _, checkpoint := range pending {
+		if err := bot.DB.WithContext(ctx).Save(&checkpoint).Error; err != nil {
+			log.WithField("channel", checkpoint.ChannelID).WithError(err).Warn("unable to save checkpoint")
+		}
+	}
+}
+
+// flushCheckpointsPeriodically flushes the checkpoints every
+// checkpointFlushInterval, so few are lost if the bot stops unexpectedly.
+func (bot *AmputatorBot) flushCheckpointsPeriodically() {
+	for range time.Tick(checkpointFlushInterval) {
+		bot.FlushCheckpoints(context.Background())
+	}
+}
+
+// catchUp goes through every channel that was active recently and handles
+// the messages posted in it since the bot last saw it, up to the message
+// ID before. Messages from then on arrive as they're posted.
+func (bot *AmputatorBot) catchUp(s Session, before string) {
+	ctx, span := tracer.Start(context.Background(), "catchUp")
+	defer span.End()
+
+	// Checkpoints from before a reconnect may still be in memory
+	bot.FlushCheckpoints(ctx)
+	defer bot.FlushCheckpoints(ctx)
+
+	maxAge := time.Duration(bot.Config.CatchUpMaxAgeMinutes) * time.Minute
+	if maxAge <= 0 {
+		maxAge = defaultCatchUpMaxAge
+	}
+	maxMessages := bot.Config.CatchUpMaxMessages
+	if maxMessages <= 0 {
+		maxMessages = defaultCatchUpMaxMessages
+	}
+
+	var checkpoints []ChannelCheckpoint
+	bot.DB.WithContext(ctx).Where("updated_at > ?", time.Now().Add(-maxAge)).Find(&checkpoints)
+	span.SetAttributes(attribute.Int("catch_up.channels", len(checkpoints)))
+
+	for _, checkpoint := range checkpoints {
+		sc := bot.getServerConfig(checkpoint.ServerID)
+		if sc.CatchUp == catchUpOff || !sc.AmputationEnabled {
+			continue
+		}
+		logger := log.WithFields(log.Fields{
+			"guild":   checkpoint.ServerID,
+			"channel": checkpoint.ChannelID,
+		})
+
+		// Catching up shares the scan tracker so a channel isn't caught up
+		// on and scanned at the same time
+		channelCtx, cancel := context.WithCancel(ctx)
+		if !bot.scans.start(checkpoint.ChannelID, cancel) {
+			cancel()
+			continue
+		}
+		err := bot.catchUpChannel(channelCtx, s, sc, checkpoint, time.Now().Add(-maxAge), before, maxMessages, logger)
+		bot.scans.finish(checkpoint.ChannelID)
+		cancel()
+		if err != nil {
+			logger.WithError(err).Warn("unable to catch up on channel")
+		}
+	}
+}
+
+// catchUpChannel handles up to maxMessages messages posted in a channel
+// after its checkpoint and before the message ID before, skipping any
+// older than oldest. Depending on the server's config, it replies to each
+// of them or sends one digest of all the links it amputated.
+func (bot *AmputatorBot) catchUpChannel(ctx context.Context, s Session, sc ServerConfig, checkpoint ChannelCheckpoint,
+	oldest time.Time, before string, maxMessages int, logger *log.Entry) error {
+	ctx, span := tracer.Start(ctx, "catchUpChannel")
+	defer span.End()
+
+	var missed []*discordgo.Message
+	after := checkpoint.MessageID
+	for len(missed) < maxMessages && snowflakeAfter(before, after) {
+		messages, err := s.ChannelMessages(checkpoint.ChannelID, scanPageSize, "", after, "", discordgo.WithContext(ctx))
+		if err != nil {
+			return fmt.Errorf("unable to fetch messages after %v: %w", after, err)
+		}
+		if len(messages) == 0 {
+			break
+		}
+
+		// Discord returns the newest messages first
+		sort.Slice(messages, func(i, j int) bool { return snowflakeAfter(messages[j].ID, messages[i].ID) })
+		for _, message := range messages {
+			if !message.Timestamp.Before(oldest) && snowflakeAfter(before, message.ID) {
+				missed = append(missed, message)
+			}
+		}
+		after = messages[len(messages)-1].ID
+		if len(messages) < scanPageSize {
+			break
+		}
+		if err := sleepContext(ctx, scanDelay); err != nil {
+			return err
+		}
+	}
+	missed = missed[:min(len(missed), maxMessages)]
+	span.SetAttributes(attribute.Int("catch_up.messages", len(missed)))
+	if len(missed) == 0 {
+		return nil
+	}
+	logger.WithField("messages", len(missed)).Info("catching up on missed messages")
+
+	// Checkpoints are only saved every so often, so messages that were
+	// handled before the bot went offline can come up again
+	missedIDs := make([]string, 0, len(missed))
+	for _, message := range missed {
+		missedIDs = append(missedIDs, message.ID)
+	}
+	var handledIDs []string
+	bot.DB.WithContext(ctx).Model(&AmputationEvent{}).Where("message_id IN ?", missedIDs).Pluck("message_id", &handledIDs)
+
+	language := responseLanguage(sc, "")
+	var digest []string
+	for _, message := range missed {
+		// Messages from history don't say which guild they're from
+		message.GuildID = checkpoint.ServerID
+		if slices.Contains(handledIDs, message.ID) {
+			bot.saveCheckpoint(message)
+			continue
+		}
+
+		links := bot.messageAmpLinks(s, sc, message)
+		missedLogger := messageLogger(message).WithField("catch_up", true)
+		switch {
+		case len(links) == 0:
+		case sc.CatchUp == catchUpReply:
+			if err := bot.handleMessageWithAmpUrls(ctx, s, &discordgo.MessageCreate{Message: message}, links, missedLogger); err != nil {
+				missedLogger.WithError(err).Warn("unable to handle missed message with AMP urls")
+			}
+			if err := sleepContext(ctx, scanDelay); err != nil {
+				bot.saveCheckpoint(message)
+				return err
+			}
+		default:
+			for _, amputation := range bot.recordAmputations(ctx, sc, message, links, missedLogger) {
+				if amputation.ResponseURL != "" {
+					digest = append(digest, fmt.Sprintf("**%v**: %v", message.Author.Username,
+						formatLink(amputation.ResponseURL, amputation, false)))
+				}
+			}
+		}
+		bot.saveCheckpoint(message)
+	}
+
+	if len(digest) == 0 {
+		return nil
+	}
+	bot.sendMessage(ctx, s, sc.UseEmbed, false, &discordgo.Message{ChannelID: checkpoint.ChannelID}, &discordgo.MessageEmbed{
+		Title:       translate(language, "Missed While Offline"),
+		Description: digestDescription(language, digest),
+	}, logger)
+	return nil
+}
+
+// digestDescription joins the lines of a catch up digest, leaving off the
+// ones that don't fit.
+func digestDescription(language string, lines []string) string {
+	description := ""
+	for i, line := range lines {
+		if len(description)+len(line)+1 > maxDigestLength {
+			return description + translate(language, "…and %v more", len(lines)-i)
+		}
+		description += line + "\n"
+	}
+	return strings.TrimSuffix(description, "\n")
+}
@@ -0,0 +1,152 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/google/uuid"
+	log "github.com/sirupsen/logrus"
+)
+
+// Where a config change came from.
+const (
+	configSourceText  string = "text command"
+	configSourceSlash string = "slash command"
+	configSourceAPI   string = "admin API"
+
+	maxConfigHistoryLength int = 10
+)
+
+// A ConfigChange is one setting changed on a server, kept so changes can
+// be audited and reverted. Setting is the name of the ServerConfig field.
+type ConfigChange struct {
+	CreatedAt time.Time
+	UUID      string `gorm:"primaryKey"`
+	ServerID  string
+	UserID    string
+	Username  string
+	Setting   string
+	OldValue  string
+	NewValue  string
+	Source    string
+}
+
+// diffConfig returns the settings that differ between two configs.
+func diffConfig(before, after ServerConfig) []ConfigChange {
+	var changes []ConfigChange
+	beforeValues := convertFlatStructToSliceStringMap(before)
+	for i, afterValue := range convertFlatStructToSliceStringMap(after) {
+		for field, value := range afterValue {
+			if old := beforeValues[i][field]; old != value {
+				changes = append(changes, ConfigChange{Setting: field, OldValue: old, NewValue: value})
+			}
+		}
+	}
+	return changes
+}
+
+// recordConfigChange stores every setting changed on a server since
+// before, who changed it and how, and posts the changes to the server's
+// log channel. The user is nil for changes from the admin API.
+func (bot *AmputatorBot) recordConfigChange(ctx context.Context, s Session, guildID string, user *discordgo.User,
+	source string, before ServerConfig, logger *log.Entry) []ConfigChange {
+	after := bot.getServerConfig(guildID)
+	language := responseLanguage(after, "")
+	who := source
+	changes := diffConfig(before, after)
+	var lines []string
+	for i := range changes {
+		changes[i].UUID = uuid.New().String()
+		changes[i].ServerID = guildID
+		changes[i].Source = source
+		if user != nil {
+			changes[i].UserID = user.ID
+			changes[i].Username = user.Username
+			who = user.Mention()
+		}
+		lines = append(lines, fmt.Sprintf("%v: `%v` → `%v`",
+			translate(language, getTagValue(after, changes[i].Setting, "pretty")), changes[i].OldValue, changes[i].NewValue))
+	}
+	if len(changes) == 0 {
+		return nil
+	}
+
+	if tx := bot.DB.WithContext(ctx).Create(&changes); tx.Error != nil {
+		logger.WithError(tx.Error).Error("unable to record config change")
+	}
+	bot.logToServer(ctx, s, guildID, logConfigChanges,
+		translate(language, "%v changed:", who)+"\n"+strings.Join(lines, "\n"), logger)
+	return changes
+}
+
+// configHistory returns a server's most recent config changes, newest
+// first.
+func (bot *AmputatorBot) configHistory(ctx context.Context, guildID string, limit int) []ConfigChange {
+	var changes []ConfigChange
+	bot.DB.WithContext(ctx).Where(&ConfigChange{ServerID: guildID}).Order("created_at desc").Limit(limit).Find(&changes)
+	return changes
+}
+
+// configHistoryEmbed lists a server's most recent config changes.
+func (bot *AmputatorBot) configHistoryEmbed(ctx context.Context, guildID string, language string) *discordgo.MessageEmbed {
+	embed := &discordgo.MessageEmbed{Title: translate(language, "Config History")}
+	changes := bot.configHistory(ctx, guildID, maxConfigHistoryLength)
+	if len(changes) == 0 {
+		embed.Description = translate(language, "No config changes yet")
+		return embed
+	}
+	for _, change := range changes {
+		who := change.Source
+		if change.UserID != "" {
+			who = "<@" + change.UserID + ">"
+		}
+		embed.Fields = append(embed.Fields, &discordgo.MessageEmbedField{
+			Name: change.UUID,
+			Value: fmt.Sprintf("%v: `%v` → `%v`\n", translate(language, getTagValue(ServerConfig{}, change.Setting, "pretty")),
+				change.OldValue, change.NewValue) +
+				translate(language, "By %v via %v <t:%v:R>", who, translate(language, change.Source), change.CreatedAt.Unix()),
+		})
+	}
+	return embed
+}
+
+// revertConfigChange sets a setting back to what it was before a change.
+// The revert is recorded as a change of its own.
+func (bot *AmputatorBot) revertConfigChange(ctx context.Context, s Session, guildID string, changeUUID string,
+	user *discordgo.User, source string, logger *log.Entry) (ConfigChange, error) {
+	var change ConfigChange
+	bot.DB.WithContext(ctx).Where(&ConfigChange{UUID: changeUUID, ServerID: guildID}).Find(&change)
+	if change.UUID == "" {
+		return change, fmt.Errorf("no config change with id %v", changeUUID)
+	}
+
+	field, ok := reflect.TypeOf(ServerConfig{}).FieldByName(change.Setting)
+	if !ok {
+		return change, fmt.Errorf("unknown setting %v", change.Setting)
+	}
+	var value any
+	var err error
+	switch field.Type.Kind() {
+	case reflect.Bool:
+		value, err = strconv.ParseBool(change.OldValue)
+	case reflect.Int:
+		value, err = strconv.Atoi(change.OldValue)
+	default:
+		value = change.OldValue
+	}
+	if err != nil {
+		return change, fmt.Errorf("unable to parse old value %v of %v: %w", change.OldValue, change.Setting, err)
+	}
+
+	if err := bot.updateSettings(ctx, s, guildID, map[string]any{change.Setting: value}, user, source, logger); err != nil {
+		return change, fmt.Errorf("unable to revert %v: %w", change.Setting, err)
+	}
+	logger.WithFields(log.Fields{"setting": change.Setting, "value": change.OldValue, "change": change.UUID}).
+		Info("server config reverted")
+	return change, nil
+}
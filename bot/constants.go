@@ -1,8 +1,62 @@
 package bot
 
+import "time"
+
+const (
+	commandPrefix          string = "!amp"
+	statsCommand           string = "stats"
+	configCommand          string = "config"
+	templateCommand        string = "template"
+	reviewCommand          string = "review"
+	ruleCommand            string = "rule"
+	scanCommand            string = "scan"
+	defaultAmputatorAPIURL string = "https://www.amputatorbot.com/api/v1"
+	amputatorUserAgent     string = "github.com/tyzbit/go-discord-amputator"
+	compactLayout          string = "compact"
+	richLayout             string = "rich"
+	replyMode              string = "reply"
+	replaceMode            string = "replace"
+	catchUpOff             string = "off"
+	catchUpReply           string = "reply"
+	catchUpDigest          string = "digest"
+	rateLimitDrop          string = "drop"
+	rateLimitBatch         string = "batch"
+	rateLimitReact         string = "react"
+	duplicateReply         string = "reply"
+	duplicateReact         string = "react"
+	duplicateLink          string = "link"
+)
+
 const (
-	ampRegex      string = ".*[./-]amp[-./]?.*"
-	commandPrefix string = "!amp"
-	statsCommand  string = "stats"
-	configCommand string = "config"
+	defaultAPITimeout          time.Duration = time.Second * 10
+	maxEmbedsPerMessage        int           = 10
+	maxEmbedFields             int           = 25
+	maxEmbedFieldLength        int           = 1024
+	maxEmbedLength             int           = 6000
+	maxAutocompleteChoices     int           = 25
+	maxEmbedTitleLength        int           = 256
+	maxEmbedAuthorLength       int           = 256
+	defaultAPIRetries          int           = 2
+	defaultAPIBreakerThreshold int           = 5
+	defaultAPIBreakerCooldown  time.Duration = time.Minute
+	defaultCatchUpMaxAge       time.Duration = time.Hour * 6
+	defaultCatchUpMaxMessages  int           = 200
+	defaultUserRateLimit       int           = 10
+	defaultChannelRateLimit    int           = 30
+	defaultServerRateLimit     int           = 100
+	defaultDuplicateWindow     int           = 10
+)
+
+var (
+	// startupDelay is how long BotReady waits before it starts updating the
+	// bot's status.
+	startupDelay time.Duration = time.Second * 10
+
+	// scanDelay is how long channel scans wait between requests to
+	// Discord, on top of its rate limits.
+	scanDelay time.Duration = time.Second
+
+	// rateLimitBatchDelay is how long messages over a rate limit are
+	// collected for before their links are amputated in one reply.
+	rateLimitBatchDelay time.Duration = time.Second * 30
 )
@@ -0,0 +1,152 @@
+package bot
+
+import (
+	"net/netip"
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/mvdan/xurls"
+)
+
+// How much each kind of evidence counts towards a URL being an AMP URL.
+const (
+	ampCacheScore   int = 3
+	strongAmpSignal int = 2
+	weakAmpSignal   int = 1
+)
+
+// Sensitivity settings and the score a URL needs to be amputated at each.
+const (
+	lowSensitivity     string = "low"
+	mediumSensitivity  string = "medium"
+	highSensitivity    string = "high"
+	defaultSensitivity string = mediumSensitivity
+)
+
+var sensitivityThresholds = map[string]int{
+	lowSensitivity:    ampCacheScore,
+	mediumSensitivity: strongAmpSignal,
+	highSensitivity:   weakAmpSignal,
+}
+
+// ampCacheHosts serve copies of other sites' AMP pages. Subdomains are
+// included.
+var ampCacheHosts = []string{"cdn.ampproject.org", "bing-amp.com"}
+
+// ampQueryValues are query parameters whose value marks an AMP page.
+var ampQueryValues = map[string][]string{
+	"outputtype": {"amp"},
+	"output":     {"amp"},
+	"amp":        {"", "1", "true"},
+}
+
+// isAmpCacheHost reports whether a URL is served from an AMP cache,
+// including Google's /amp/ viewer.
+func isAmpCacheHost(u *url.URL) bool {
+	host := strings.ToLower(u.Hostname())
+	for _, cache := range ampCacheHosts {
+		if host == cache || strings.HasSuffix(host, "."+cache) {
+			return true
+		}
+	}
+	googleHost := host == "google.com" || strings.HasPrefix(host, "www.google.")
+	return googleHost && strings.HasPrefix(strings.ToLower(u.Path), "/amp/")
+}
+
+// ampScore scores how likely it is that a URL is an AMP URL from its host,
+// path segments and query parameters.
+func ampScore(u *url.URL) int {
+	if isAmpCacheHost(u) {
+		return ampCacheScore
+	}
+
+	score := 0
+	labels := strings.Split(strings.ToLower(u.Hostname()), ".")
+	if len(labels) > 2 && labels[0] == "amp" {
+		score += strongAmpSignal
+	}
+
+	// Segments that are exactly "amp" or end in .amp(.html) are strong
+	// evidence, "amp" as a word inside a segment like story-amp is weak.
+	pathScore := 0
+	for _, segment := range strings.Split(strings.ToLower(u.Path), "/") {
+		switch {
+		case segment == "amp", strings.HasSuffix(segment, ".amp"), strings.HasSuffix(segment, ".amp.html"):
+			pathScore = max(pathScore, strongAmpSignal)
+		case hasAmpWord(segment):
+			pathScore = max(pathScore, weakAmpSignal)
+		}
+	}
+	score += pathScore
+
+	for key, values := range u.Query() {
+		wanted, ok := ampQueryValues[strings.ToLower(key)]
+		if !ok {
+			continue
+		}
+		for _, value := range values {
+			for _, w := range wanted {
+				if strings.EqualFold(value, w) {
+					score += strongAmpSignal
+				}
+			}
+		}
+	}
+	return score
+}
+
+// hasPublicSuffix reports whether a host is an IP address or a name under
+// a public top-level domain, so names like "intranet" aren't taken for
+// links to amputate.
+func hasPublicSuffix(host string) bool {
+	if _, err := netip.ParseAddr(host); err == nil {
+		return true
+	}
+	labels := strings.Split(strings.TrimSuffix(strings.ToLower(host), "."), ".")
+	if len(labels) < 2 || slices.Contains(labels, "") {
+		return false
+	}
+	_, ok := slices.BinarySearch(xurls.TLDs, labels[len(labels)-1])
+	return ok
+}
+
+// hasAmpWord reports whether "amp" is one of the words of a path segment
+// split on dashes, underscores and dots.
+func hasAmpWord(segment string) bool {
+	words := strings.FieldsFunc(segment, func(r rune) bool {
+		return r == '-' || r == '_' || r == '.'
+	})
+	for _, word := range words {
+		if word == "amp" {
+			return true
+		}
+	}
+	return false
+}
+
+// isAmpURL reports whether a URL scores high enough to be amputated at a
+// sensitivity. Unknown sensitivities are treated as the default.
+func isAmpURL(rawURL string, sensitivity string) bool {
+	u, err := url.Parse(rawURL)
+	if err != nil || !hasPublicSuffix(u.Hostname()) {
+		return false
+	}
+	threshold, ok := sensitivityThresholds[sensitivity]
+	if !ok {
+		threshold = sensitivityThresholds[defaultSensitivity]
+	}
+	return ampScore(u) >= threshold
+}
+
+// filterAmpLinks returns the links that look like AMP URLs at a
+// sensitivity.
+func filterAmpLinks(links []messageLink, sensitivity string) []messageLink {
+	var ampLinks []messageLink
+	for _, link := range links {
+		if isAmpURL(link.URL, sensitivity) {
+			ampLinks = append(ampLinks, link)
+		}
+	}
+	return ampLinks
+}
@@ -0,0 +1,232 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// maxDuplicateWindow is the longest any server can have links
+	// remembered for, so older ones can be forgotten.
+	maxDuplicateWindow time.Duration = time.Hour * 24
+
+	// handledMessageTTL is how long message IDs are remembered for to
+	// catch Discord delivering the same message twice.
+	handledMessageTTL time.Duration = time.Hour
+
+	duplicateEmoji string = "🔁"
+)
+
+// trackingParameters are dropped from URLs before comparing them.
+var trackingParameters = []string{"utm_", "fbclid", "gclid", "mc_cid", "mc_eid"}
+
+// A recentLink is a link amputated in a channel, and the reply it got.
+type recentLink struct {
+	ReplyURL string
+	At       time.Time
+}
+
+// A linkMemory keeps the links recently amputated in each channel, keyed
+// by their normalized URL, along with the IDs of messages already handled.
+type linkMemory struct {
+	mu        sync.Mutex
+	byChannel map[string]map[string]recentLink
+	handled   map[string]time.Time
+	pruned    time.Time
+}
+
+// claim records a message as handled, reporting false if it already was.
+func (l *linkMemory) claim(messageID string, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.prune(now)
+	if _, ok := l.handled[messageID]; ok {
+		return false
+	}
+	l.handled[messageID] = now
+	return true
+}
+
+// recent returns the reply to a link if it was amputated in a channel
+// within the window.
+func (l *linkMemory) recent(channelID string, key string, window time.Duration, now time.Time) (recentLink, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	link, ok := l.byChannel[channelID][key]
+	if !ok || now.Sub(link.At) > window {
+		return recentLink{}, false
+	}
+	return link, true
+}
+
+// remember records links amputated in a channel and the reply they got.
+func (l *linkMemory) remember(channelID string, keys []string, replyURL string, now time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.byChannel[channelID] == nil {
+		l.byChannel[channelID] = map[string]recentLink{}
+	}
+	for _, key := range keys {
+		l.byChannel[channelID][key] = recentLink{ReplyURL: replyURL, At: now}
+	}
+}
+
+// prune forgets links and messages that are too old to matter. It runs at
+// most once a minute.
+func (l *linkMemory) prune(now time.Time) {
+	if now.Sub(l.pruned) < time.Minute {
+		return
+	}
+	for channelID, links := range l.byChannel {
+		for key, link := range links {
+			if now.Sub(link.At) > maxDuplicateWindow {
+				delete(links, key)
+			}
+		}
+		if len(links) == 0 {
+			delete(l.byChannel, channelID)
+		}
+	}
+	for messageID, at := range l.handled {
+		if now.Sub(at) > handledMessageTTL {
+			delete(l.handled, messageID)
+		}
+	}
+	l.pruned = now
+}
+
+func newLinkMemory() *linkMemory {
+	return &linkMemory{
+		byChannel: map[string]map[string]recentLink{},
+		handled:   map[string]time.Time{},
+	}
+}
+
+// normalizeLinkURL returns a URL in a form that's the same for links to
+// the same page: without the scheme, www., fragment, tracking parameters
+// or trailing slash, and with the query sorted.
+func normalizeLinkURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+	query := u.Query()
+	for parameter := range query {
+		for _, tracking := range trackingParameters {
+			if strings.HasPrefix(strings.ToLower(parameter), tracking) {
+				query.Del(parameter)
+			}
+		}
+	}
+	normalized := strings.TrimPrefix(strings.ToLower(u.Host), "www.") + strings.TrimSuffix(u.EscapedPath(), "/")
+	if len(query) > 0 {
+		normalized += "?" + query.Encode()
+	}
+	return normalized
+}
+
+// duplicateWindow returns how long a server wants links remembered for.
+func duplicateWindow(sc ServerConfig) time.Duration {
+	return min(time.Duration(sc.DuplicateWindow)*time.Minute, maxDuplicateWindow)
+}
+
+// filterDuplicates splits a message's links into the ones not amputated
+// in its channel within the server's window, and the replies to the ones
+// that were.
+func (bot *AmputatorBot) filterDuplicates(m *discordgo.Message, sc ServerConfig, links []messageLink) ([]messageLink, []string) {
+	if sc.DuplicateAction == duplicateReply {
+		return links, nil
+	}
+	var fresh []messageLink
+	var replies []string
+	seen := map[string]bool{}
+	for _, link := range links {
+		earlier, ok := bot.recentLinks.recent(m.ChannelID, normalizeLinkURL(link.URL), duplicateWindow(sc), time.Now())
+		if !ok {
+			fresh = append(fresh, link)
+			continue
+		}
+		if !seen[earlier.ReplyURL] {
+			seen[earlier.ReplyURL] = true
+			replies = append(replies, earlier.ReplyURL)
+		}
+	}
+	return fresh, replies
+}
+
+// filterDuplicateAmputations splits amputated links into the ones that
+// lead to a page not amputated in the channel within the server's window,
+// and the replies to the ones that do. Links leading to the same page as
+// an earlier link in the message are dropped.
+func (bot *AmputatorBot) filterDuplicateAmputations(m *discordgo.Message, sc ServerConfig,
+	amputations []Amputation) ([]Amputation, []string) {
+	var fresh []Amputation
+	var replies []string
+	seen := map[string]bool{}
+	for _, amputation := range amputations {
+		key := normalizeLinkURL(amputation.ResponseURL)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		if sc.DuplicateAction == duplicateReply {
+			fresh = append(fresh, amputation)
+			continue
+		}
+		earlier, ok := bot.recentLinks.recent(m.ChannelID, key, duplicateWindow(sc), time.Now())
+		if !ok {
+			fresh = append(fresh, amputation)
+			continue
+		}
+		if !slices.Contains(replies, earlier.ReplyURL) {
+			replies = append(replies, earlier.ReplyURL)
+		}
+	}
+	return fresh, replies
+}
+
+// rememberAmputations records the links amputated in a message and where
+// they lead, so they're recognized if they're posted in the channel again.
+func (bot *AmputatorBot) rememberAmputations(m *discordgo.Message, amputations []Amputation, reply *discordgo.Message) {
+	var keys []string
+	for _, amputation := range amputations {
+		if amputation.ResponseURL != "" {
+			keys = append(keys, normalizeLinkURL(amputation.RequestURL), normalizeLinkURL(amputation.ResponseURL))
+		}
+	}
+	bot.recentLinks.remember(m.ChannelID, keys, messageURL(m.GuildID, reply.ChannelID, reply.ID), time.Now())
+}
+
+// answerDuplicate answers a message whose links were all amputated in its
+// channel recently, by reacting to it or linking to the earlier replies.
+func (bot *AmputatorBot) answerDuplicate(ctx context.Context, s Session, m *discordgo.Message, sc ServerConfig,
+	replies []string, logger *log.Entry) {
+	logger.WithField("action", sc.DuplicateAction).Info("links were already amputated recently")
+	if sc.DuplicateAction == duplicateReact {
+		if err := s.MessageReactionAdd(m.ChannelID, m.ID, duplicateEmoji, discordgo.WithContext(ctx)); err != nil {
+			logger.WithError(err).Warn("unable to react to message with duplicate links")
+		}
+		return
+	}
+	language := responseLanguage(sc, "")
+	bot.sendMessage(ctx, s, sc.UseEmbed, sc.ReplyToOriginalMessage, m, &discordgo.MessageEmbed{
+		Title:       translate(language, "Already Amputated"),
+		Description: translate(language, "These links were amputated recently: %v", strings.Join(replies, " ")),
+	}, logger)
+}
+
+// messageURL returns the link to a message. Messages in DMs have no guild.
+func messageURL(guildID, channelID, messageID string) string {
+	if guildID == "" {
+		guildID = "@me"
+	}
+	return fmt.Sprintf("https://discord.com/channels/%v/%v/%v", guildID, channelID, messageID)
+}
@@ -1,6 +1,8 @@
 package bot
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"time"
 
 	"github.com/bwmarrin/discordgo"
@@ -30,7 +32,61 @@ type AmputationEvent struct {
 	ChannelId      string
 	MessageId      string
 	ServerID       string
-	Amputations    []Amputation `gorm:"foreignKey:AmputationEventUUID"`
+	ReplyMessageId string
+
+	// The other messages of a reply that was split across several,
+	// separated by spaces
+	ReplyPartIds string
+
+	Amputations  []Amputation       `gorm:"foreignKey:AmputationEventUUID"`
+	Interactions []InteractionEvent `gorm:"foreignKey:AmputationEventUUID"`
+}
+
+// An InteractionEvent is created when someone uses one of the buttons on
+// an amputation reply, or reports a wrong link.
+type InteractionEvent struct {
+	CreatedAt           time.Time
+	UUID                string `gorm:"primaryKey"`
+	AmputationEventUUID string
+	Action              string
+	UserId              string
+	Username            string
+	ServerID            string
+}
+
+// An AmputationReport is made when someone reports that an amputation
+// gave the wrong link. Admins review them with the review command.
+type AmputationReport struct {
+	CreatedAt           time.Time
+	UUID                string `gorm:"primaryKey"`
+	AmputationEventUUID string
+	ReporterId          string
+	ReporterUsername    string
+	ServerID            string
+	Comment             string
+	Status              string `gorm:"default:pending"`
+	ReviewedBy          string
+	ReviewedAt          *time.Time
+}
+
+// A URLOverride is an admin-approved result for a request URL. It wins
+// over the cache and every resolver. An empty ResponseURL means the URL
+// can't be amputated. It's keyed by a hash of the request URL, since URLs
+// can be longer than databases allow keys to be.
+type URLOverride struct {
+	CreatedAt      time.Time
+	UpdatedAt      time.Time
+	RequestURLHash string `gorm:"primaryKey;size:64"`
+	RequestURL     string `gorm:"type:text"`
+	ResponseURL    string `gorm:"type:text"`
+	ReportUUID     string
+	AddedBy        string
+}
+
+// newURLOverride returns an override for a request URL.
+func newURLOverride(requestURL string) URLOverride {
+	hash := sha256.Sum256([]byte(requestURL))
+	return URLOverride{RequestURLHash: hex.EncodeToString(hash[:]), RequestURL: requestURL}
 }
 
 // This is the representation of request and response URLs from users or
@@ -45,6 +101,21 @@ type Amputation struct {
 	ResponseURL         string
 	ResponseDomainName  string
 	Cached              bool
+	Resolver            string
+	RewriteRule         string
+	Title               string
+	Publisher           string
+	ImageURL            string
+	FaviconURL          string
+	PublishedAt         *time.Time
+
+	// How the link was written in the message, so the reply matches
+	Label      string
+	Suppressed bool
+	Spoiler    bool
+
+	// Why the link couldn't be amputated, if it couldn't
+	Failure string `gorm:"-"`
 }
 
 // createMessageEvent logs a given message event into the database.
@@ -0,0 +1,255 @@
+package bot
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// fakeReaction is a reaction added through a fakeSession.
+type fakeReaction struct {
+	ChannelID string
+	MessageID string
+	Emoji     string
+}
+
+// fakeSession is an in-memory Session that records everything the bot
+// sends so tests can make assertions about it.
+type fakeSession struct {
+	mu sync.Mutex
+
+	user      *discordgo.User
+	guilds    map[string]*discordgo.Guild
+	channels  map[string]*discordgo.Channel
+	nextID    int
+	sent      []*discordgo.Message
+	edits     []*discordgo.MessageEdit
+	reactions []fakeReaction
+	statuses  []discordgo.UpdateStatusData
+	typing    []string
+	deleted   []string
+	history   map[string][]*discordgo.Message
+	responses []*discordgo.InteractionResponse
+
+	permissions map[string]int64
+	webhooks    []*discordgo.Webhook
+	executed    []*discordgo.WebhookParams
+	commands    []*discordgo.ApplicationCommand
+}
+
+func newFakeSession() *fakeSession {
+	return &fakeSession{
+		user:     &discordgo.User{ID: "100", Username: "Amputator", Bot: true},
+		guilds:   map[string]*discordgo.Guild{},
+		channels: map[string]*discordgo.Channel{},
+		history:  map[string][]*discordgo.Message{},
+
+		// The bot and the test user can do anything, anyone else nothing
+		permissions: map[string]int64{"100": discordgo.PermissionAll, "500": discordgo.PermissionAll},
+	}
+}
+
+// addGuild makes a guild available to Guild lookups.
+func (s *fakeSession) addGuild(g *discordgo.Guild) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.guilds[g.ID] = g
+}
+
+// addChannel makes a channel available to Channel lookups.
+func (s *fakeSession) addChannel(c *discordgo.Channel) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.channels[c.ID] = c
+}
+
+// addHistory adds messages to a channel's history, newest first.
+func (s *fakeSession) addHistory(channelID string, messages ...*discordgo.Message) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.history[channelID] = append(s.history[channelID], messages...)
+}
+
+// sentMessages returns a copy of the messages sent so far.
+func (s *fakeSession) sentMessages() []*discordgo.Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]*discordgo.Message{}, s.sent...)
+}
+
+func (s *fakeSession) record(m *discordgo.Message) *discordgo.Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.nextID++
+	m.ID = fmt.Sprintf("%v", 1000+s.nextID)
+	m.Author = s.user
+
+	// Discord fills in the type of embeds sent without one
+	embeds := make([]*discordgo.MessageEmbed, 0, len(m.Embeds))
+	for _, embed := range m.Embeds {
+		if embed.Type == "" {
+			typed := *embed
+			typed.Type = discordgo.EmbedTypeRich
+			embed = &typed
+		}
+		embeds = append(embeds, embed)
+	}
+	m.Embeds = embeds
+	s.sent = append(s.sent, m)
+	return m
+}
+
+func (s *fakeSession) BotUser() *discordgo.User {
+	return s.user
+}
+
+func (s *fakeSession) Guild(guildID string, options ...discordgo.RequestOption) (*discordgo.Guild, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	g, ok := s.guilds[guildID]
+	if !ok {
+		return nil, fmt.Errorf("unknown guild: %v", guildID)
+	}
+	return g, nil
+}
+
+func (s *fakeSession) Channel(channelID string, options ...discordgo.RequestOption) (*discordgo.Channel, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, ok := s.channels[channelID]
+	if !ok {
+		return nil, fmt.Errorf("unknown channel: %v", channelID)
+	}
+	return c, nil
+}
+
+func (s *fakeSession) ChannelTyping(channelID string, options ...discordgo.RequestOption) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.typing = append(s.typing, channelID)
+	return nil
+}
+
+func (s *fakeSession) ChannelMessages(channelID string, limit int, beforeID, afterID, aroundID string, options ...discordgo.RequestOption) ([]*discordgo.Message, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	history := s.history[channelID]
+	for i, m := range history {
+		if m.ID == beforeID {
+			history = history[i+1:]
+			break
+		}
+		// Like Discord, the oldest messages after afterID are returned,
+		// newest first
+		if m.ID == afterID {
+			history = history[max(0, i-limit):i]
+			break
+		}
+	}
+	return history[:min(len(history), limit)], nil
+}
+
+func (s *fakeSession) ChannelMessageSendComplex(channelID string, data *discordgo.MessageSend, options ...discordgo.RequestOption) (*discordgo.Message, error) {
+	return s.record(&discordgo.Message{
+		ChannelID:        channelID,
+		Content:          data.Content,
+		Embeds:           data.Embeds,
+		Components:       data.Components,
+		MessageReference: data.Reference,
+		Flags:            data.Flags,
+	}), nil
+}
+
+func (s *fakeSession) ChannelMessageDelete(channelID, messageID string, options ...discordgo.RequestOption) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.deleted = append(s.deleted, messageID)
+	return nil
+}
+
+func (s *fakeSession) InteractionRespond(interaction *discordgo.Interaction, resp *discordgo.InteractionResponse, options ...discordgo.RequestOption) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.responses = append(s.responses, resp)
+	return nil
+}
+
+func (s *fakeSession) ChannelMessageEditComplex(m *discordgo.MessageEdit, options ...discordgo.RequestOption) (*discordgo.Message, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.edits = append(s.edits, m)
+	return &discordgo.Message{ID: m.ID, ChannelID: m.Channel}, nil
+}
+
+func (s *fakeSession) ApplicationCommandBulkOverwrite(appID string, guildID string, commands []*discordgo.ApplicationCommand, options ...discordgo.RequestOption) ([]*discordgo.ApplicationCommand, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.commands = commands
+	return commands, nil
+}
+
+func (s *fakeSession) UserChannelPermissions(userID, channelID string, options ...discordgo.RequestOption) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.permissions[userID], nil
+}
+
+func (s *fakeSession) ChannelWebhooks(channelID string, options ...discordgo.RequestOption) ([]*discordgo.Webhook, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var webhooks []*discordgo.Webhook
+	for _, w := range s.webhooks {
+		if w.ChannelID == channelID {
+			webhooks = append(webhooks, w)
+		}
+	}
+	return webhooks, nil
+}
+
+func (s *fakeSession) WebhookCreate(channelID, name, avatar string, options ...discordgo.RequestOption) (*discordgo.Webhook, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.nextID++
+	w := &discordgo.Webhook{
+		ID:        fmt.Sprintf("%v", 1000+s.nextID),
+		ChannelID: channelID,
+		Name:      name,
+		Token:     "token",
+		User:      s.user,
+	}
+	s.webhooks = append(s.webhooks, w)
+	return w, nil
+}
+
+func (s *fakeSession) WebhookExecute(webhookID, token string, wait bool, data *discordgo.WebhookParams, options ...discordgo.RequestOption) (*discordgo.Message, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, w := range s.webhooks {
+		if w.ID == webhookID && w.Token == token {
+			s.nextID++
+			s.executed = append(s.executed, data)
+			return &discordgo.Message{
+				ID:        fmt.Sprintf("%v", 1000+s.nextID),
+				ChannelID: w.ChannelID,
+				WebhookID: webhookID,
+				Content:   data.Content,
+			}, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown webhook: %v", webhookID)
+}
+
+func (s *fakeSession) MessageReactionAdd(channelID, messageID, emojiID string, options ...discordgo.RequestOption) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.reactions = append(s.reactions, fakeReaction{ChannelID: channelID, MessageID: messageID, Emoji: emojiID})
+	return nil
+}
+
+func (s *fakeSession) UpdateStatusComplex(usd discordgo.UpdateStatusData) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.statuses = append(s.statuses, usd)
+	return nil
+}
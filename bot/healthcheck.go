@@ -7,7 +7,16 @@ import (
 	"github.com/gin-gonic/gin"
 )
 
-func (b AmputatorBot) StartHealthAPI() {
+// StartHealthAPI serves the health API, and the admin API if it's enabled,
+// on port 8080.
+func (b *AmputatorBot) StartHealthAPI() {
+	go b.healthAPI().Run(":8080")
+}
+
+// healthAPI serves /healthcheck, which reports the database status and
+// the state of the Amputator API circuit breaker. An open breaker does not
+// make the bot unhealthy since other resolvers are still tried.
+func (b *AmputatorBot) healthAPI() *gin.Engine {
 	app := gin.New()
 	app.Use(
 		// Disable logging for healthcheck endpoint and favicon
@@ -27,7 +36,11 @@ func (b AmputatorBot) StartHealthAPI() {
 			content = fmt.Sprintf("Error pinging db: %v", pingResult)
 			status = http.StatusInternalServerError
 		}
+		breaker := b.breaker
+		content += fmt.Sprintf("\nAmputator API circuit breaker: %v (%v consecutive failures)",
+			breaker.State(), breaker.Failures())
 		c.String(status, content)
 	})
-	go app.Run(":8080")
+	b.registerAdminAPI(app)
+	return app
 }
@@ -0,0 +1,369 @@
+package bot
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+const defaultLanguage string = "en"
+
+// catalogs holds the translations for every supported language other than
+// English, keyed by the English message. Messages missing from a catalog
+// fall back to English.
+var catalogs = map[string]map[string]string{
+	"de": {
+		// Replies
+		"Amputated Link":    "Amputierter Link",
+		"Amputated Links":   "Amputierte Links",
+		"Amputated from %v": "Amputiert von %v",
+		"Amputation Stats":  "Amputationsstatistik",
+		"Amputator Config":  "Amputator-Einstellungen",
+		"Setting Updated":   "Einstellung aktualisiert",
+		"%v set to %v":      "%v auf %v gesetzt",
+		"Unable to set %v":  "%v konnte nicht gesetzt werden",
+		"See %v for usage":  "Siehe %v für die Verwendung",
+		"none":              "keine",
+		"Invalid Template":  "Ungültige Vorlage",
+		"Template Preview":  "Vorlagenvorschau",
+		"Placeholders: %v":  "Platzhalter: %v",
+
+		// Buttons
+		"Delete":                      "Löschen",
+		"Wrong link?":                 "Falscher Link?",
+		"Show original":               "Original anzeigen",
+		"Hide original":               "Original ausblenden",
+		"Report a wrong link":         "Falschen Link melden",
+		"What's wrong with the link?": "Was stimmt mit dem Link nicht?",
+		"Only the person who posted the link or a moderator can delete this reply.": "Nur die Person, die den Link gepostet hat, oder ein Moderator kann diese Antwort löschen.",
+		"Reply deleted.":                       "Antwort gelöscht.",
+		"Thanks, your report has been saved.":  "Danke, deine Meldung wurde gespeichert.",
+		"This reply can no longer be changed.": "Diese Antwort kann nicht mehr geändert werden.",
+
+		// Reviews
+		"Review Queue":         "Prüfwarteschlange",
+		"No reports to review": "Keine Meldungen zu prüfen",
+		"Report Reviewed":      "Meldung geprüft",
+		"Reported by %v":       "Gemeldet von %v",
+
+		// Rules
+		"Rewrite Rules": "Umschreiberegeln",
+		"Rule Added":    "Regel hinzugefügt",
+		"Rule Removed":  "Regel entfernt",
+		"Built-in":      "Eingebaut",
+		"Global":        "Global",
+		"Server":        "Server",
+
+		// Scans
+		"Channel Scan":                                          "Kanalscan",
+		"Scanning %v":                                           "Scanne %v",
+		"Finished scanning %v":                                  "Scan von %v abgeschlossen",
+		"Cancelled scanning %v":                                 "Scan von %v abgebrochen",
+		"Stopped scanning %v after an error":                    "Scan von %v nach einem Fehler gestoppt",
+		"Cancelling the scan of %v":                             "Breche den Scan von %v ab",
+		"No scan is running in %v":                              "In %v läuft kein Scan",
+		"A scan is already running in %v":                       "In %v läuft bereits ein Scan",
+		"%v messages checked, %v AMP links found, %v amputated": "%v Nachrichten geprüft, %v AMP-Links gefunden, %v amputiert",
+
+		// Catching up
+		"Missed While Offline": "Verpasst, während der Bot offline war",
+		"…and %v more":         "…und %v weitere",
+
+		// Replacing
+		"Replying to %v": "Antwort auf %v",
+
+		// Duplicates
+		"Already Amputated":                       "Bereits amputiert",
+		"These links were amputated recently: %v": "Diese Links wurden kürzlich amputiert: %v",
+
+		// Log channel
+		"Config Changed":      "Konfiguration geändert",
+		"Amputation Failed":   "Amputation fehlgeschlagen",
+		"Missing Permissions": "Fehlende Berechtigungen",
+		"Rate Limited":        "Ratenbegrenzung",
+		"%v changed:":         "%v hat geändert:",
+
+		// Config history
+		"Config History":        "Konfigurationsverlauf",
+		"No config changes yet": "Noch keine Konfigurationsänderungen",
+		"By %v via %v <t:%v:R>": "Von %v über %v <t:%v:R>",
+		"text command":          "Textbefehl",
+		"slash command":         "Slash-Befehl",
+		"admin API":             "Admin-API",
+
+		// Settings
+		"Unable to change config":                "Konfiguration konnte nicht geändert werden",
+		"a number from %v to %v":                 "eine Zahl von %v bis %v",
+		"a channel like #bot-log, or off":        "ein Kanal wie #bot-log, oder off",
+		"%v to %v characters without spaces":     "%v bis %v Zeichen ohne Leerzeichen",
+		"(default `%v`)":                         "(Standard `%v`)",
+		"Allowed values":                         "Erlaubte Werte",
+		"Current value":                          "Aktueller Wert",
+		"Default value":                          "Standardwert",
+		"Every setting was reset to its default": "Alle Einstellungen wurden zurückgesetzt",
+		"%v reset to its default":                "%v wurde zurückgesetzt",
+		"This command only works in servers":     "Dieser Befehl funktioniert nur in Servern",
+
+		// Errors
+		"only people with the Manage Server permission can change this": "nur Personen mit der Berechtigung „Server verwalten“ können das ändern",
+		"%v must be on or off, not %v":                                  "%v muss on oder off sein, nicht %v",
+		"%v must be a number from %v to %v, not %v":                     "%v muss eine Zahl von %v bis %v sein, nicht %v",
+		"%v must be one of %v, not %v":                                  "%v muss eines von %v sein, nicht %v",
+		"%v must be a channel or off, not %v":                           "%v muss ein Kanal oder off sein, nicht %v",
+		"%v must be %v to %v characters without spaces, not %v":         "%v muss %v bis %v Zeichen ohne Leerzeichen lang sein, nicht %v",
+		"unknown setting %v, expected one of %v":                        "unbekannte Einstellung %v, erwartet wird eine von %v",
+		"expected a setting and a value":                                "eine Einstellung und ein Wert werden erwartet",
+		"Unable to amputate links in %v":                                "Links in %v konnten nicht amputiert werden",
+		"The Amputator API rate limited the bot":                        "Die Amputator-API hat den Bot ausgebremst",
+
+		// Stats
+		"Messages Acted On":      "Bearbeitete Nachrichten",
+		"Messages Sent":          "Gesendete Nachrichten",
+		"Calls to Amputator API": "Aufrufe der Amputator-API",
+		"URLs Amputated":         "Amputierte URLs",
+		"Top 5 Domains":          "Top 5 Domains",
+		"Servers Watched":        "Beobachtete Server",
+		"Messages Rate Limited":  "Begrenzte Nachrichten",
+
+		// Config
+		"Server ID":                               "Server-ID",
+		"Server Name":                             "Servername",
+		"Amputation Enabled":                      "Amputation aktiviert",
+		"Reply to original message":               "Auf ursprüngliche Nachricht antworten",
+		"Use embed to reply":                      "Mit Embed antworten",
+		"Guess at AMP URLs if they are difficult": "AMP-URLs erraten, wenn sie schwierig sind",
+		"How many links deep to go to try to find the non-AMP link": "Wie viele Links tief nach dem Nicht-AMP-Link gesucht wird",
+		"Reply layout (compact or rich)":                            "Antwortlayout (compact oder rich)",
+		"Language":                                                  "Sprache",
+		"Reply template":                                            "Antwortvorlage",
+		"AMP link detection sensitivity (low, medium or high)":      "Empfindlichkeit der AMP-Link-Erkennung (low, medium oder high)",
+		"Amputate links from other bots and webhooks":               "Links von anderen Bots und Webhooks amputieren",
+		"Reply mode (reply or replace)":                             "Antwortmodus (reply oder replace)",
+		"Hide previews on messages with AMP links":                  "Vorschauen von Nachrichten mit AMP-Links ausblenden",
+		"Show previews in plain text replies":                       "Vorschauen in Textantworten anzeigen",
+		"Catch up on missed messages (off, reply or digest)":        "Verpasste Nachrichten nachholen (off, reply oder digest)",
+		"Log channel":             "Log-Kanal",
+		"Log config changes":      "Konfigurationsänderungen protokollieren",
+		"Log amputation failures": "Fehlgeschlagene Amputationen protokollieren",
+		"Log permission problems": "Berechtigungsprobleme protokollieren",
+		"Log rate limits":         "Ratenbegrenzungen protokollieren",
+		"Command prefix":          "Befehlspräfix",
+		"What to do with messages over a rate limit (drop, batch or react)":              "Umgang mit Nachrichten über dem Limit (drop, batch oder react)",
+		"Messages handled per user per minute (0 for no limit)":                          "Bearbeitete Nachrichten pro Nutzer und Minute (0 für kein Limit)",
+		"Messages handled per channel per minute (0 for no limit)":                       "Bearbeitete Nachrichten pro Kanal und Minute (0 für kein Limit)",
+		"Messages handled per server per minute (0 for no limit)":                        "Bearbeitete Nachrichten pro Server und Minute (0 für kein Limit)",
+		"What to do with links amputated recently in the channel (reply, react or link)": "Umgang mit kürzlich im Kanal amputierten Links (reply, react oder link)",
+		"Minutes links are remembered for in each channel":                               "Minuten, die Links in jedem Kanal gemerkt werden",
+	},
+	"es": {
+		// Replies
+		"Amputated Link":    "Enlace amputado",
+		"Amputated Links":   "Enlaces amputados",
+		"Amputated from %v": "Amputado de %v",
+		"Amputation Stats":  "Estadísticas de amputación",
+		"Amputator Config":  "Configuración de Amputator",
+		"Setting Updated":   "Ajuste actualizado",
+		"%v set to %v":      "%v establecido en %v",
+		"Unable to set %v":  "No se pudo establecer %v",
+		"See %v for usage":  "Consulta %v para ver cómo usarlo",
+		"none":              "ninguno",
+		"Invalid Template":  "Plantilla no válida",
+		"Template Preview":  "Vista previa de la plantilla",
+		"Placeholders: %v":  "Marcadores: %v",
+
+		// Buttons
+		"Delete":                      "Eliminar",
+		"Wrong link?":                 "¿Enlace incorrecto?",
+		"Show original":               "Mostrar original",
+		"Hide original":               "Ocultar original",
+		"Report a wrong link":         "Informar de un enlace incorrecto",
+		"What's wrong with the link?": "¿Qué le pasa al enlace?",
+		"Only the person who posted the link or a moderator can delete this reply.": "Solo quien publicó el enlace o un moderador puede eliminar esta respuesta.",
+		"Reply deleted.":                       "Respuesta eliminada.",
+		"Thanks, your report has been saved.":  "Gracias, tu informe se ha guardado.",
+		"This reply can no longer be changed.": "Esta respuesta ya no se puede cambiar.",
+
+		// Reviews
+		"Review Queue":         "Cola de revisión",
+		"No reports to review": "No hay informes que revisar",
+		"Report Reviewed":      "Informe revisado",
+		"Reported by %v":       "Informado por %v",
+
+		// Rules
+		"Rewrite Rules": "Reglas de reescritura",
+		"Rule Added":    "Regla añadida",
+		"Rule Removed":  "Regla eliminada",
+		"Built-in":      "Integrada",
+		"Global":        "Global",
+		"Server":        "Servidor",
+
+		// Scans
+		"Channel Scan":                                          "Escaneo de canal",
+		"Scanning %v":                                           "Escaneando %v",
+		"Finished scanning %v":                                  "Escaneo de %v terminado",
+		"Cancelled scanning %v":                                 "Escaneo de %v cancelado",
+		"Stopped scanning %v after an error":                    "Escaneo de %v detenido tras un error",
+		"Cancelling the scan of %v":                             "Cancelando el escaneo de %v",
+		"No scan is running in %v":                              "No hay ningún escaneo en %v",
+		"A scan is already running in %v":                       "Ya hay un escaneo en %v",
+		"%v messages checked, %v AMP links found, %v amputated": "%v mensajes revisados, %v enlaces AMP encontrados, %v amputados",
+
+		// Catching up
+		"Missed While Offline": "Perdidos mientras estaba desconectado",
+		"…and %v more":         "…y %v más",
+
+		// Replacing
+		"Replying to %v": "En respuesta a %v",
+
+		// Duplicates
+		"Already Amputated":                       "Ya amputado",
+		"These links were amputated recently: %v": "Estos enlaces se amputaron hace poco: %v",
+
+		// Log channel
+		"Config Changed":      "Configuración cambiada",
+		"Amputation Failed":   "Amputación fallida",
+		"Missing Permissions": "Faltan permisos",
+		"Rate Limited":        "Límite de peticiones",
+		"%v changed:":         "%v cambió:",
+
+		// Config history
+		"Config History":        "Historial de configuración",
+		"No config changes yet": "Todavía no hay cambios de configuración",
+		"By %v via %v <t:%v:R>": "Por %v mediante %v <t:%v:R>",
+		"text command":          "comando de texto",
+		"slash command":         "comando de barra",
+		"admin API":             "API de administración",
+
+		// Settings
+		"Unable to change config":                "No se pudo cambiar la configuración",
+		"a number from %v to %v":                 "un número de %v a %v",
+		"a channel like #bot-log, or off":        "un canal como #bot-log, u off",
+		"%v to %v characters without spaces":     "de %v a %v caracteres sin espacios",
+		"(default `%v`)":                         "(predeterminado `%v`)",
+		"Allowed values":                         "Valores permitidos",
+		"Current value":                          "Valor actual",
+		"Default value":                          "Valor predeterminado",
+		"Every setting was reset to its default": "Todos los ajustes se restablecieron",
+		"%v reset to its default":                "%v se restableció",
+		"This command only works in servers":     "Este comando solo funciona en servidores",
+
+		// Errors
+		"only people with the Manage Server permission can change this": "solo las personas con el permiso Gestionar servidor pueden cambiar esto",
+		"%v must be on or off, not %v":                                  "%v debe ser on u off, no %v",
+		"%v must be a number from %v to %v, not %v":                     "%v debe ser un número de %v a %v, no %v",
+		"%v must be one of %v, not %v":                                  "%v debe ser uno de %v, no %v",
+		"%v must be a channel or off, not %v":                           "%v debe ser un canal u off, no %v",
+		"%v must be %v to %v characters without spaces, not %v":         "%v debe tener de %v a %v caracteres sin espacios, no %v",
+		"unknown setting %v, expected one of %v":                        "ajuste desconocido %v, se esperaba uno de %v",
+		"expected a setting and a value":                                "se esperaba un ajuste y un valor",
+		"Unable to amputate links in %v":                                "No se pudieron amputar los enlaces de %v",
+		"The Amputator API rate limited the bot":                        "La API de Amputator limitó al bot",
+
+		// Stats
+		"Messages Acted On":      "Mensajes procesados",
+		"Messages Sent":          "Mensajes enviados",
+		"Calls to Amputator API": "Llamadas a la API de Amputator",
+		"URLs Amputated":         "URLs amputadas",
+		"Top 5 Domains":          "Los 5 dominios principales",
+		"Servers Watched":        "Servidores observados",
+		"Messages Rate Limited":  "Mensajes limitados",
+
+		// Config
+		"Server ID":                               "ID del servidor",
+		"Server Name":                             "Nombre del servidor",
+		"Amputation Enabled":                      "Amputación activada",
+		"Reply to original message":               "Responder al mensaje original",
+		"Use embed to reply":                      "Responder con un embed",
+		"Guess at AMP URLs if they are difficult": "Adivinar URLs AMP si son difíciles",
+		"How many links deep to go to try to find the non-AMP link": "Cuántos enlaces seguir para encontrar el enlace no AMP",
+		"Reply layout (compact or rich)":                            "Diseño de respuesta (compact o rich)",
+		"Language":                                                  "Idioma",
+		"Reply template":                                            "Plantilla de respuesta",
+		"AMP link detection sensitivity (low, medium or high)":      "Sensibilidad de detección de enlaces AMP (low, medium o high)",
+		"Amputate links from other bots and webhooks":               "Amputar enlaces de otros bots y webhooks",
+		"Reply mode (reply or replace)":                             "Modo de respuesta (reply o replace)",
+		"Hide previews on messages with AMP links":                  "Ocultar vistas previas de mensajes con enlaces AMP",
+		"Show previews in plain text replies":                       "Mostrar vistas previas en respuestas de texto",
+		"Catch up on missed messages (off, reply or digest)":        "Ponerse al día con mensajes perdidos (off, reply o digest)",
+		"Log channel":             "Canal de registro",
+		"Log config changes":      "Registrar cambios de configuración",
+		"Log amputation failures": "Registrar amputaciones fallidas",
+		"Log permission problems": "Registrar problemas de permisos",
+		"Log rate limits":         "Registrar límites de peticiones",
+		"Command prefix":          "Prefijo de comandos",
+		"What to do with messages over a rate limit (drop, batch or react)":              "Qué hacer con mensajes sobre el límite (drop, batch o react)",
+		"Messages handled per user per minute (0 for no limit)":                          "Mensajes procesados por usuario por minuto (0 sin límite)",
+		"Messages handled per channel per minute (0 for no limit)":                       "Mensajes procesados por canal por minuto (0 sin límite)",
+		"Messages handled per server per minute (0 for no limit)":                        "Mensajes procesados por servidor por minuto (0 sin límite)",
+		"What to do with links amputated recently in the channel (reply, react or link)": "Qué hacer con enlaces amputados hace poco en el canal (reply, react o link)",
+		"Minutes links are remembered for in each channel":                               "Minutos que se recuerdan los enlaces en cada canal",
+	},
+}
+
+// translate returns message in the given language, formatted with args if
+// there are any.
+func translate(language string, message string, args ...any) string {
+	if translated, ok := catalogs[language][message]; ok {
+		message = translated
+	}
+	if len(args) > 0 {
+		return fmt.Sprintf(message, args...)
+	}
+	return message
+}
+
+// supportedLanguage reports whether there are translations for a language.
+func supportedLanguage(language string) bool {
+	_, ok := catalogs[language]
+	return ok || language == defaultLanguage
+}
+
+// languageForLocale maps a Discord locale such as "es-ES" or "de" to a
+// supported language, defaulting to English.
+func languageForLocale(locale discordgo.Locale) string {
+	language, _, _ := strings.Cut(strings.ToLower(string(locale)), "-")
+	if supportedLanguage(language) {
+		return language
+	}
+	return defaultLanguage
+}
+
+// responseLanguage picks the language to respond in. A user's own locale,
+// which Discord only sends with interactions, wins over the server's
+// language setting if there are translations for it.
+func responseLanguage(sc ServerConfig, userLocale discordgo.Locale) string {
+	language, _, _ := strings.Cut(strings.ToLower(string(userLocale)), "-")
+	if language != "" && supportedLanguage(language) {
+		return language
+	}
+	if supportedLanguage(sc.Language) {
+		return sc.Language
+	}
+	return defaultLanguage
+}
+
+// A translatableError is an error shown to people. It keeps its message
+// and arguments apart so it can be translated when it's sent.
+type translatableError struct {
+	message string
+	args    []any
+}
+
+func newTranslatableError(message string, args ...any) error {
+	return translatableError{message: message, args: args}
+}
+
+func (e translatableError) Error() string {
+	return translate(defaultLanguage, e.message, e.args...)
+}
+
+// translateError returns an error's message in the given language, if
+// it's a translatableError. Other errors, including ones that wrap a
+// translatableError, are returned as they are.
+func translateError(language string, err error) string {
+	if translatable, ok := err.(translatableError); ok {
+		return translate(language, translatable.message, translatable.args...)
+	}
+	return err.Error()
+}
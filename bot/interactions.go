@@ -0,0 +1,287 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/google/uuid"
+	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Custom IDs of the buttons and modal on amputation replies. The
+// AmputationEvent UUID is appended after a colon.
+const (
+	deleteAction       string = "amputation_delete"
+	reportAction       string = "amputation_report"
+	showOriginalAction string = "amputation_show_original"
+	hideOriginalAction string = "amputation_hide_original"
+	reportCommentID    string = "comment"
+)
+
+// amputationComponents returns the buttons for an amputation reply. The
+// original links can only be toggled on compact replies.
+func amputationComponents(eventUUID string, language string, showingOriginal bool, toggle bool) []discordgo.MessageComponent {
+	buttons := []discordgo.MessageComponent{
+		discordgo.Button{
+			Label:    translate(language, "Delete"),
+			Style:    discordgo.DangerButton,
+			CustomID: deleteAction + ":" + eventUUID,
+		},
+		discordgo.Button{
+			Label:    translate(language, "Wrong link?"),
+			Style:    discordgo.SecondaryButton,
+			CustomID: reportAction + ":" + eventUUID,
+		},
+	}
+	if toggle {
+		button := discordgo.Button{
+			Label:    translate(language, "Show original"),
+			Style:    discordgo.SecondaryButton,
+			CustomID: showOriginalAction + ":" + eventUUID,
+		}
+		if showingOriginal {
+			button.Label = translate(language, "Hide original")
+			button.CustomID = hideOriginalAction + ":" + eventUUID
+		}
+		buttons = append(buttons, button)
+	}
+	return []discordgo.MessageComponent{discordgo.ActionsRow{Components: buttons}}
+}
+
+// interactionUser returns who used an interaction, which Discord sends
+// differently for guilds and DMs.
+func interactionUser(i *discordgo.Interaction) *discordgo.User {
+	if i.Member != nil {
+		return i.Member.User
+	}
+	return i.User
+}
+
+// InteractionCreate is called whenever someone uses a button on one of the
+// bot's replies, submits a modal or uses a slash command.
+func (bot *AmputatorBot) InteractionCreate(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	bot.interactionCreate(DiscordSession{s}, i)
+}
+
+func (bot *AmputatorBot) interactionCreate(s Session, i *discordgo.InteractionCreate) {
+	var customID string
+	switch i.Type {
+	case discordgo.InteractionMessageComponent:
+		customID = i.MessageComponentData().CustomID
+	case discordgo.InteractionModalSubmit:
+		customID = i.ModalSubmitData().CustomID
+	case discordgo.InteractionApplicationCommand:
+		bot.applicationCommand(s, i)
+		return
+	case discordgo.InteractionApplicationCommandAutocomplete:
+		bot.autocomplete(s, i)
+		return
+	default:
+		return
+	}
+	action, eventUUID, _ := strings.Cut(customID, ":")
+
+	ctx, span := tracer.Start(context.Background(), "InteractionCreate", trace.WithAttributes(
+		attribute.String("discord.guild", i.GuildID),
+		attribute.String("discord.channel", i.ChannelID),
+		attribute.String("discord.interaction.action", action),
+	))
+	defer span.End()
+
+	logger := interactionLogger(i.Interaction).WithFields(log.Fields{
+		"action":           action,
+		"amputation_event": eventUUID,
+	})
+	logger.Info("interaction used")
+
+	var err error
+	switch action {
+	case deleteAction:
+		err = bot.handleDeleteInteraction(ctx, s, i.Interaction, eventUUID, logger)
+	case reportAction:
+		err = bot.handleReportInteraction(ctx, s, i.Interaction, eventUUID, logger)
+	case showOriginalAction, hideOriginalAction:
+		err = bot.handleOriginalInteraction(ctx, s, i.Interaction, eventUUID, action, logger)
+	default:
+		logger.Warn("unknown interaction used")
+		return
+	}
+
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		logger.WithError(err).Warn("problem handling interaction")
+	}
+}
+
+// getInteractionEvent looks up the AmputationEvent an interaction is for
+// along with the config and language to respond with.
+func (bot *AmputatorBot) getInteractionEvent(i *discordgo.Interaction, eventUUID string) (AmputationEvent, ServerConfig, string) {
+	var event AmputationEvent
+	bot.DB.Preload("Amputations").Where(&AmputationEvent{UUID: eventUUID}).Find(&event)
+
+	sc := directMessageConfig
+	if i.GuildID != "" {
+		sc = bot.getServerConfig(i.GuildID)
+	}
+	return event, sc, responseLanguage(sc, i.Locale)
+}
+
+// createInteractionEvent records an interaction with an amputation reply.
+func (bot *AmputatorBot) createInteractionEvent(ctx context.Context, i *discordgo.Interaction, action string, eventUUID string) {
+	user := interactionUser(i)
+	bot.DB.WithContext(ctx).Create(&InteractionEvent{
+		UUID:                uuid.New().String(),
+		AmputationEventUUID: eventUUID,
+		Action:              action,
+		UserId:              user.ID,
+		Username:            user.Username,
+		ServerID:            i.GuildID,
+	})
+}
+
+// respondEphemeral responds to an interaction with a message only the
+// person who used it can see.
+func respondEphemeral(ctx context.Context, s Session, i *discordgo.Interaction, content string) error {
+	return s.InteractionRespond(i, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{
+			Content: content,
+			Flags:   discordgo.MessageFlagsEphemeral,
+		},
+	}, discordgo.WithContext(ctx))
+}
+
+// handleDeleteInteraction deletes an amputation reply if it was used by
+// the author of the original message or someone who can manage messages.
+func (bot *AmputatorBot) handleDeleteInteraction(ctx context.Context, s Session, i *discordgo.Interaction, eventUUID string, logger *log.Entry) error {
+	event, _, language := bot.getInteractionEvent(i, eventUUID)
+	if event.UUID == "" {
+		return respondEphemeral(ctx, s, i, translate(language, "This reply can no longer be changed."))
+	}
+
+	moderator := i.Member != nil && i.Member.Permissions&discordgo.PermissionManageMessages != 0
+	if interactionUser(i).ID != event.AuthorId && !moderator {
+		logger.Info("denied deleting reply to someone else's message")
+		return respondEphemeral(ctx, s, i,
+			translate(language, "Only the person who posted the link or a moderator can delete this reply."))
+	}
+
+	if err := s.ChannelMessageDelete(i.ChannelID, i.Message.ID, discordgo.WithContext(ctx)); err != nil {
+		return fmt.Errorf("unable to delete reply: %w", err)
+	}
+	for _, part := range strings.Fields(event.ReplyPartIds) {
+		if err := s.ChannelMessageDelete(i.ChannelID, part, discordgo.WithContext(ctx)); err != nil {
+			logger.WithError(err).Warn("unable to delete part of reply")
+		}
+	}
+	bot.createInteractionEvent(ctx, i, deleteAction, eventUUID)
+	return respondEphemeral(ctx, s, i, translate(language, "Reply deleted."))
+}
+
+// handleReportInteraction opens a modal asking what's wrong with a link
+// when the button is used, and saves the report when the modal is
+// submitted.
+func (bot *AmputatorBot) handleReportInteraction(ctx context.Context, s Session, i *discordgo.Interaction, eventUUID string, logger *log.Entry) error {
+	event, _, language := bot.getInteractionEvent(i, eventUUID)
+	if event.UUID == "" {
+		return respondEphemeral(ctx, s, i, translate(language, "This reply can no longer be changed."))
+	}
+
+	if i.Type == discordgo.InteractionMessageComponent {
+		return s.InteractionRespond(i, &discordgo.InteractionResponse{
+			Type: discordgo.InteractionResponseModal,
+			Data: &discordgo.InteractionResponseData{
+				CustomID: reportAction + ":" + eventUUID,
+				Title:    translate(language, "Report a wrong link"),
+				Components: []discordgo.MessageComponent{
+					discordgo.ActionsRow{Components: []discordgo.MessageComponent{
+						discordgo.TextInput{
+							CustomID:  reportCommentID,
+							Label:     translate(language, "What's wrong with the link?"),
+							Style:     discordgo.TextInputParagraph,
+							Required:  false,
+							MaxLength: 500,
+						},
+					}},
+				},
+			},
+		}, discordgo.WithContext(ctx))
+	}
+
+	var comment string
+	for _, row := range i.ModalSubmitData().Components {
+		actionsRow, ok := row.(*discordgo.ActionsRow)
+		if !ok {
+			continue
+		}
+		for _, component := range actionsRow.Components {
+			if input, ok := component.(*discordgo.TextInput); ok && input.CustomID == reportCommentID {
+				comment = input.Value
+			}
+		}
+	}
+
+	bot.createInteractionEvent(ctx, i, reportAction, eventUUID)
+	user := interactionUser(i)
+	bot.DB.WithContext(ctx).Create(&AmputationReport{
+		UUID:                uuid.New().String(),
+		AmputationEventUUID: eventUUID,
+		ReporterId:          user.ID,
+		ReporterUsername:    user.Username,
+		ServerID:            i.GuildID,
+		Comment:             comment,
+		Status:              pendingReport,
+	})
+	logger.WithField("comment", comment).Info("wrong link reported")
+	return respondEphemeral(ctx, s, i, translate(language, "Thanks, your report has been saved."))
+}
+
+// handleOriginalInteraction switches a compact reply between showing the
+// original links next to the amputated ones and the server's template.
+func (bot *AmputatorBot) handleOriginalInteraction(ctx context.Context, s Session, i *discordgo.Interaction, eventUUID string, action string, logger *log.Entry) error {
+	show := action == showOriginalAction
+	event, sc, language := bot.getInteractionEvent(i, eventUUID)
+	if event.UUID == "" {
+		return respondEphemeral(ctx, s, i, translate(language, "This reply can no longer be changed."))
+	}
+
+	var links []Amputation
+	for _, amputation := range event.Amputations {
+		if amputation.ResponseURL != "" {
+			links = append(links, amputation)
+		}
+	}
+	template := sc.ReplyTemplate
+	if show {
+		template = "{original} → {url}"
+	}
+	description := renderReplyTemplate(template, replyTemplateData{
+		Author: "<@" + event.AuthorId + ">",
+		Links:  links,
+	})
+
+	// Keep the rest of the reply as it was. Plain text replies can have
+	// embeds too, but only Discord's link previews, so only a rich embed is
+	// the bot's own.
+	data := &discordgo.InteractionResponseData{
+		Components: amputationComponents(eventUUID, language, show, true),
+	}
+	if len(i.Message.Embeds) > 0 && i.Message.Embeds[0].Type == discordgo.EmbedTypeRich {
+		embed := *i.Message.Embeds[0]
+		embed.Description = description
+		data.Embeds = []*discordgo.MessageEmbed{&embed}
+	} else {
+		data.Content = description
+	}
+
+	bot.createInteractionEvent(ctx, i, action, eventUUID)
+	return s.InteractionRespond(i, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseUpdateMessage,
+		Data: data,
+	}, discordgo.WithContext(ctx))
+}
@@ -0,0 +1,151 @@
+package bot
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/mvdan/xurls"
+)
+
+var (
+	// codeBlockRegex matches fenced code blocks and inline code, which
+	// Discord doesn't turn into links.
+	codeBlockRegex = regexp.MustCompile("(?s)```.*?```|`[^`\n]+`")
+
+	// spoilerRegex matches text hidden with ||spoiler|| tags.
+	spoilerRegex = regexp.MustCompile(`(?s)\|\|.+?\|\|`)
+
+	// maskedLinkRegex matches [label](url) and [label](<url>).
+	maskedLinkRegex = regexp.MustCompile(`\[([^\[\]\n]+)\]\(\s*(<)?(https?://[^\s()<>]+)>?\s*\)`)
+
+	// suppressedLinkRegex matches <url>, which has no preview.
+	suppressedLinkRegex = regexp.MustCompile(`<(https?://[^\s<>]+)>`)
+)
+
+// A messageLink is a link in a Discord message along with how it was
+// written, so the reply can be written the same way.
+type messageLink struct {
+	URL        string
+	Label      string
+	Suppressed bool
+	Spoiler    bool
+
+	// position is where the link starts in the message, used to keep
+	// links in order.
+	position int
+}
+
+// extractLinks finds the links in a Discord message the way Discord shows
+// them: links in code are skipped, and masked, suppressed and spoilered
+// links are noted as such.
+func extractLinks(content string) []messageLink {
+	// Code is blanked out instead of removed so positions still line up
+	text := codeBlockRegex.ReplaceAllStringFunc(content, blank)
+	spoilers := spoilerRegex.FindAllStringIndex(text, -1)
+	inSpoiler := func(position int) bool {
+		for _, spoiler := range spoilers {
+			if position >= spoiler[0] && position < spoiler[1] {
+				return true
+			}
+		}
+		return false
+	}
+
+	var links []messageLink
+	for _, match := range maskedLinkRegex.FindAllStringSubmatchIndex(text, -1) {
+		links = append(links, messageLink{
+			URL:        text[match[6]:match[7]],
+			Label:      text[match[2]:match[3]],
+			Suppressed: match[4] != -1,
+			Spoiler:    inSpoiler(match[0]),
+			position:   match[0],
+		})
+	}
+	text = maskedLinkRegex.ReplaceAllStringFunc(text, blank)
+
+	for _, match := range suppressedLinkRegex.FindAllStringSubmatchIndex(text, -1) {
+		links = append(links, messageLink{
+			URL:        text[match[2]:match[3]],
+			Suppressed: true,
+			Spoiler:    inSpoiler(match[0]),
+			position:   match[0],
+		})
+	}
+	text = suppressedLinkRegex.ReplaceAllStringFunc(text, blank)
+
+	for _, match := range xurls.Strict.FindAllStringIndex(text, -1) {
+		links = append(links, messageLink{
+			URL:      text[match[0]:match[1]],
+			Spoiler:  inSpoiler(match[0]),
+			position: match[0],
+		})
+	}
+
+	sort.Slice(links, func(i, j int) bool { return links[i].position < links[j].position })
+	return links
+}
+
+// messageLinks returns the links in a message's content, its embeds and
+// any messages forwarded in it, in that order. Each URL is only returned
+// once, as it was first written, since Discord adds a preview embed for
+// links in the content.
+func messageLinks(m *discordgo.Message) []messageLink {
+	links := extractLinks(m.Content)
+	for _, embed := range m.Embeds {
+		links = append(links, embedLinks(embed)...)
+	}
+	for _, snapshot := range m.MessageSnapshots {
+		if snapshot.Message != nil {
+			links = append(links, messageLinks(snapshot.Message)...)
+		}
+	}
+
+	seen := map[string]bool{}
+	unique := links[:0]
+	for _, link := range links {
+		if !seen[link.URL] {
+			seen[link.URL] = true
+			unique = append(unique, link)
+		}
+	}
+	return unique
+}
+
+// embedLinks returns the links in an embed's URLs and text.
+func embedLinks(e *discordgo.MessageEmbed) []messageLink {
+	var links []messageLink
+	if e.URL != "" {
+		links = append(links, messageLink{URL: e.URL})
+	}
+	if e.Author != nil && e.Author.URL != "" {
+		links = append(links, messageLink{URL: e.Author.URL})
+	}
+	links = append(links, extractLinks(e.Title)...)
+	links = append(links, extractLinks(e.Description)...)
+	for _, field := range e.Fields {
+		links = append(links, extractLinks(field.Value)...)
+	}
+	return links
+}
+
+// blank replaces a string with as many spaces.
+func blank(s string) string {
+	return strings.Repeat(" ", len(s))
+}
+
+// formatLink writes a URL the way the link it replaces was written. The
+// label is left off when withLabel is false.
+func formatLink(url string, a Amputation, withLabel bool) string {
+	if a.Suppressed {
+		url = "<" + url + ">"
+	}
+	if withLabel && a.Label != "" {
+		url = "[" + a.Label + "](" + url + ")"
+	}
+	if a.Spoiler {
+		url = "||" + url + "||"
+	}
+	return url
+}
@@ -0,0 +1,141 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	log "github.com/sirupsen/logrus"
+)
+
+// Categories of events posted to a server's log channel.
+const (
+	logConfigChanges string = "config"
+	logFailures      string = "failures"
+	logPermissions   string = "permissions"
+	logRateLimits    string = "ratelimits"
+
+	// rateLimitLogInterval is the least time between rate limit posts to a
+	// server's log channel, since rate limits tend to come in bursts.
+	rateLimitLogInterval time.Duration = time.Minute
+
+	maxEmbedDescriptionLength int = 4096
+)
+
+var (
+	// logCategoryTitles are the titles of log channel posts by category.
+	logCategoryTitles = map[string]string{
+		logConfigChanges: "Config Changed",
+		logFailures:      "Amputation Failed",
+		logPermissions:   "Missing Permissions",
+		logRateLimits:    "Rate Limited",
+	}
+
+	rateLimitChannelRegex = regexp.MustCompile(`/channels/(\d+)`)
+)
+
+// A rateLimitLog tracks when rate limits were last posted to each
+// server's log channel.
+type rateLimitLog struct {
+	mu     sync.Mutex
+	posted map[string]time.Time
+}
+
+// due reports whether a rate limit can be posted to a server's log
+// channel, and if so records that it was.
+func (l *rateLimitLog) due(guildID string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if time.Since(l.posted[guildID]) < rateLimitLogInterval {
+		return false
+	}
+	l.posted[guildID] = time.Now()
+	return true
+}
+
+func newRateLimitLog() *rateLimitLog {
+	return &rateLimitLog{posted: map[string]time.Time{}}
+}
+
+// logs reports whether a server wants a category of events posted to its
+// log channel.
+func (sc ServerConfig) logs(category string) bool {
+	if sc.LogChannel == "" {
+		return false
+	}
+	switch category {
+	case logConfigChanges:
+		return sc.LogConfigChanges
+	case logFailures:
+		return sc.LogFailures
+	case logPermissions:
+		return sc.LogPermissions
+	case logRateLimits:
+		return sc.LogRateLimits
+	}
+	return false
+}
+
+// logToServer posts an event to a server's log channel, if it has one and
+// wants events of that category.
+func (bot *AmputatorBot) logToServer(ctx context.Context, s Session, guildID string, category string,
+	description string, logger *log.Entry) {
+	if guildID == "" {
+		return
+	}
+	sc := bot.getServerConfig(guildID)
+	if !sc.logs(category) {
+		return
+	}
+	if category == logRateLimits && !bot.rateLimits.due(guildID) {
+		return
+	}
+	if err := serverChannel(s, guildID, sc.LogChannel); err != nil {
+		logger.WithError(err).WithField("log_channel", sc.LogChannel).Warn("not posting to log channel")
+		return
+	}
+
+	if runes := []rune(description); len(runes) > maxEmbedDescriptionLength {
+		description = string(runes[:maxEmbedDescriptionLength-1]) + "…"
+	}
+	_, err := s.ChannelMessageSendComplex(sc.LogChannel, &discordgo.MessageSend{
+		Embeds: []*discordgo.MessageEmbed{{
+			Title:       translate(responseLanguage(sc, ""), logCategoryTitles[category]),
+			Description: description,
+			Timestamp:   time.Now().Format(time.RFC3339),
+		}},
+		AllowedMentions: &discordgo.MessageAllowedMentions{},
+	}, discordgo.WithContext(ctx))
+	if err != nil {
+		logger.WithError(err).WithField("log_channel", sc.LogChannel).Warn("unable to post to log channel")
+	}
+}
+
+// RateLimit is called when a request to Discord hits a rate limit. It's
+// posted to the log channel of the server the request was for, if any.
+func (bot *AmputatorBot) RateLimit(s *discordgo.Session, r *discordgo.RateLimit) {
+	match := rateLimitChannelRegex.FindStringSubmatch(r.URL)
+	if match == nil || s.State == nil || r.TooManyRequests == nil {
+		return
+	}
+	channel, err := s.State.Channel(match[1])
+	if err != nil {
+		return
+	}
+	bot.rateLimit(DiscordSession{s}, channel, r)
+}
+
+func (bot *AmputatorBot) rateLimit(s Session, channel *discordgo.Channel, r *discordgo.RateLimit) {
+	logger := log.WithFields(log.Fields{"guild": channel.GuildID, "channel": channel.ID})
+	logger.WithField("retry_after", r.RetryAfter).Warn("rate limited by discord")
+
+	// Posting about the log channel's own rate limits would only add to them
+	if channel.ID == bot.getServerConfig(channel.GuildID).LogChannel {
+		return
+	}
+	bot.logToServer(context.Background(), s, channel.GuildID, logRateLimits,
+		fmt.Sprintf("Discord rate limited the bot in <#%v>, retrying after %v", channel.ID, r.RetryAfter), logger)
+}
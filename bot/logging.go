@@ -0,0 +1,44 @@
+package bot
+
+import (
+	"github.com/bwmarrin/discordgo"
+	log "github.com/sirupsen/logrus"
+)
+
+// messageLogger returns a logger carrying the fields that identify a message
+// so that every line logged while handling it can be tied back together.
+func messageLogger(m *discordgo.Message) *log.Entry {
+	fields := log.Fields{
+		"guild":   m.GuildID,
+		"channel": m.ChannelID,
+		"message": m.ID,
+	}
+	if m.Author != nil {
+		fields["author"] = m.Author.ID
+		fields["author_name"] = m.Author.Username
+	}
+	return log.WithFields(fields)
+}
+
+// guildLogger returns a logger carrying the fields that identify a guild.
+func guildLogger(g *discordgo.Guild) *log.Entry {
+	return log.WithFields(log.Fields{
+		"guild":      g.ID,
+		"guild_name": g.Name,
+	})
+}
+
+// interactionLogger returns a logger carrying the fields that identify an
+// interaction and who used it.
+func interactionLogger(i *discordgo.Interaction) *log.Entry {
+	fields := log.Fields{
+		"guild":       i.GuildID,
+		"channel":     i.ChannelID,
+		"interaction": i.ID,
+	}
+	if user := interactionUser(i); user != nil {
+		fields["author"] = user.ID
+		fields["author_name"] = user.Username
+	}
+	return log.WithFields(fields)
+}
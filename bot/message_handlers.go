@@ -1,230 +1,483 @@
 package bot
 
 import (
+	"context"
 	"fmt"
+	"slices"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/bwmarrin/discordgo"
 	"github.com/google/uuid"
-	"github.com/mvdan/xurls"
 	log "github.com/sirupsen/logrus"
-	goamputate "github.com/tyzbit/go-amputate"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
 )
 
 // typeInChannel sets the typing indicator for a channel. The indicator is cleared
 // when a message is sent.
-func typeInChannel(channel chan bool, s *discordgo.Session, channelID string) {
+func typeInChannel(channel chan bool, s Session, channelID string, logger *log.Entry) {
 	for {
+		if err := s.ChannelTyping(channelID); err != nil {
+			logger.WithError(err).Error("unable to set typing indicator")
+		}
 		select {
 		case <-channel:
 			return
-		default:
-			if err := s.ChannelTyping(channelID); err != nil {
-				log.Error("unable to set typing indicator: ", err)
-			}
-			time.Sleep(time.Second * 5)
+		case <-time.After(time.Second * 5):
 		}
 	}
 }
 
 // handleMessageWithStats takes a discord session and a user ID and sends a
 // message to the user with stats about the bot.
-func (bot *AmputatorBot) handleMessageWithStats(s *discordgo.Session, m *discordgo.MessageCreate) error {
+func (bot *AmputatorBot) handleMessageWithStats(ctx context.Context, s Session, m *discordgo.MessageCreate, logger *log.Entry) error {
 	directMessage := (m.GuildID == "")
 
 	var stats botStats
 	logMessage := ""
+	language := defaultLanguage
 	if !directMessage {
+		language = responseLanguage(bot.getServerConfig(m.GuildID), "")
 		stats = bot.getServerStats(m.GuildID)
 		guild, err := s.Guild(m.GuildID)
 		if err != nil {
 			return fmt.Errorf("unable to look up guild by id: %v", m.GuildID+", "+fmt.Sprintf("%v", err))
 		}
-		logMessage = "sending " + statsCommand + " response to " + m.Author.Username + "(" + m.Author.ID + ") in " +
-			guild.Name + "(" + guild.ID + ")"
+		logger = logger.WithField("guild_name", guild.Name)
+		logMessage = "sending " + statsCommand + " response"
 	} else {
 		// We can be sure now the request was a direct message.
-		// Deny by default.
-		administrator := false
-
-	out:
-		for _, id := range bot.Config.AdminIds {
-			if m.Author.ID == id {
-				administrator = true
-
-				// This prevents us from checking all IDs now that
-				// we found a match but is a fairly ineffectual
-				// optimization since config.AdminIds will probably
-				// only have dozens of IDs at most.
-				break out
-			}
-		}
-
-		if !administrator {
+		if !bot.isAdmin(m.Author.ID) {
 			return fmt.Errorf("did not respond to %v(%v), command %v because user is not an administrator",
 				m.Author.Username, m.Author.ID, statsCommand)
 		}
 		stats = bot.getGlobalStats()
-		logMessage = "sending global " + statsCommand + " response to " + m.Author.Username + "(" + m.Author.ID + ")"
+		logMessage = "sending global " + statsCommand + " response"
 	}
 
 	// write a new statsMessageEvent to the DB
 	bot.createMessageEvent(statsCommand, m.Message)
 
+	if stats.TopDomains == "none" {
+		stats.TopDomains = translate(language, "none")
+	}
 	embed := &discordgo.MessageEmbed{
-		Title:  "Amputation Stats",
-		Fields: structToPrettyDiscordFields(stats),
+		Title:  translate(language, "Amputation Stats"),
+		Fields: structToPrettyDiscordFields(stats, language),
 	}
 
 	// Respond to statsCommand command with the formatted stats embed
-	log.Info(logMessage)
-	bot.sendMessage(s, true, false, m.Message, embed)
+	logger.Info(logMessage)
+	bot.sendMessage(ctx, s, true, false, m.Message, embed, logger)
 
 	return nil
 }
 
-// handleMessageWithAmpUrls takes a Discord session and a message string and
-// calls go-amputator with a []string of URLs parsed from the message.
+// handleMessageWithAmpUrls takes a Discord session, a message and the AMP
+// URLs found in it and resolves each URL to its canonical URL.
 // It then sends an embed with the resulting amputated URLs.
-func (bot *AmputatorBot) handleMessageWithAmpUrls(s *discordgo.Session, m *discordgo.MessageCreate) error {
-	typingStop := make(chan bool, 1)
-	go typeInChannel(typingStop, s, m.ChannelID)
-	ServerConfig := bot.getServerConfig(m.GuildID)
-	if !ServerConfig.AmputationEnabled {
-		log.Info("URLs were not amputated because automatic amputation is not enabled")
+func (bot *AmputatorBot) handleMessageWithAmpUrls(ctx context.Context, s Session, m *discordgo.MessageCreate, links []messageLink, logger *log.Entry) error {
+	ctx, span := tracer.Start(ctx, "handleMessageWithAmpUrls")
+	defer span.End()
+
+	// Discord can deliver the same message more than once, and scans and
+	// catching up can come across messages that were already handled
+	if !bot.recentLinks.claim(m.ID, time.Now()) {
+		logger.Debug("ignoring message that was already handled")
 		return nil
 	}
 
-	// Do a lookup for the full guild object
-	guild, gErr := s.Guild(m.GuildID)
-	if gErr != nil {
-		return fmt.Errorf("unable to look up guild by id: %v", m.GuildID)
+	ServerConfig := bot.getMessageConfig(m.Message)
+	if !ServerConfig.AmputationEnabled {
+		logger.Info("URLs were not amputated because automatic amputation is not enabled")
+		return nil
 	}
 
-	xurlsStrict := xurls.Strict
-	urls := xurlsStrict.FindAllString(m.Content, -1)
-	if len(urls) == 0 {
-		return fmt.Errorf("found 0 URLs in message that matched amp regex: %v", ampRegex)
+	// Links amputated in the channel recently aren't amputated again
+	links, earlierReplies := bot.filterDuplicates(m.Message, ServerConfig, links)
+	if len(links) == 0 {
+		bot.answerDuplicate(ctx, s, m.Message, ServerConfig, earlierReplies, logger)
+		return nil
 	}
 
-	log.Debug("URLs parsed from message: ", strings.Join(urls, ", "))
+	// The typing indicator is stopped before replying, or on any return
+	// before that.
+	typingStop := make(chan bool)
+	stopTyping := sync.OnceFunc(func() { close(typingStop) })
+	defer stopTyping()
+	go typeInChannel(typingStop, s, m.ChannelID, logger)
+
+	// Do a lookup for the full guild object. DMs have no guild, so their
+	// amputations are stored without a server ID and only show up in the
+	// global stats.
+	guild := &discordgo.Guild{Name: ServerConfig.Name}
+	if m.GuildID != "" {
+		var gErr error
+		guild, gErr = s.Guild(m.GuildID)
+		if gErr != nil {
+			return fmt.Errorf("unable to look up guild by id: %v", m.GuildID)
+		}
+	}
 
 	// This UUID will be used to tie together the AmputationEvent,
 	// the amputationRequestUrls and the amputationResponseUrls.
 	ampEventUUID := uuid.New().String()
+	logger = logger.WithFields(log.Fields{
+		"guild_name":       guild.Name,
+		"amputation_event": ampEventUUID,
+	})
+	urls := make([]string, 0, len(links))
+	for _, link := range links {
+		urls = append(urls, link.URL)
+	}
+	logger.WithField("urls", strings.Join(urls, ", ")).Debug("URLs parsed from message")
+	span.SetAttributes(
+		attribute.String("amputation.event", ampEventUUID),
+		attribute.Int("amputation.urls", len(urls)),
+	)
+
+	amputations, amputatedLinks := bot.amputateLinks(ctx, links, ServerConfig, guild.ID, ampEventUUID, logger)
+	var failures []string
+	for _, amputation := range amputations {
+		if amputation.Failure != "" {
+			failures = append(failures, fmt.Sprintf("<%v>: %v", amputation.RequestURL, amputation.Failure))
+		}
+	}
+	if len(failures) > 0 {
+		messageURL := fmt.Sprintf("https://discord.com/channels/%v/%v/%v", m.GuildID, m.ChannelID, m.ID)
+		bot.logToServer(ctx, s, m.GuildID, logFailures,
+			translate(responseLanguage(ServerConfig, ""), "Unable to amputate links in %v", messageURL)+"\n"+
+				strings.Join(failures, "\n"), logger)
+	}
+
+	if len(amputatedLinks) == 0 {
+		err := fmt.Errorf("unable to amputate any of the %v urls in the message", len(urls))
+		span.SetStatus(codes.Error, err.Error())
+		return err
+	}
+
+	// The same description is used for the embed and plain text replies
+	var resolved []Amputation
+	for _, amputation := range amputations {
+		if amputation.ResponseURL != "" {
+			resolved = append(resolved, amputation)
+		}
+	}
+
+	// Different links can lead to the same page, so they're also compared
+	// by where they lead once they're amputated
+	resolved, earlierReplies = bot.filterDuplicateAmputations(m.Message, ServerConfig, resolved)
+	duplicate := len(resolved) == 0
+	if duplicate {
+		stopTyping()
+		bot.answerDuplicate(ctx, s, m.Message, ServerConfig, earlierReplies, logger)
+	}
+
+	language := responseLanguage(ServerConfig, "")
+	title := translate(language, "Amputated Link")
+	if len(resolved) > 1 {
+		title = translate(language, "Amputated Links")
+	}
+
+	// In replace mode the message is reposted with its links amputated,
+	// and it's replied to as usual if that isn't possible
+	var reply *discordgo.Message
+	if !duplicate && ServerConfig.ReplyMode == replaceMode && m.GuildID != "" {
+		stopTyping()
+		var err error
+		if reply, err = bot.replaceMessage(ctx, s, m.Message, resolved, logger); err != nil {
+			logger.WithError(err).Warn("unable to replace message, replying instead")
+		}
+	}
+
+	embed := &discordgo.MessageEmbed{
+		Title: title,
+		Description: renderReplyTemplate(ServerConfig.ReplyTemplate, replyTemplateData{
+			Author: m.Author.Mention(),
+			Links:  resolved,
+		}),
+	}
+
+	// The rich layout only applies to embeds, plain text replies get
+	// Discord's own previews. Its previews would also give away spoilered
+	// links and ignore suppressed ones, so those get the compact layout.
+	richReply := !duplicate && reply == nil && ServerConfig.UseEmbed && ServerConfig.ReplyLayout == richLayout
+	for _, link := range links {
+		richReply = richReply && !link.Suppressed && !link.Spoiler
+	}
+	var richEmbeds []*discordgo.MessageEmbed
+	if richReply {
+		for i := range amputations {
+			if !slices.ContainsFunc(resolved, func(a Amputation) bool { return a.RequestURL == amputations[i].RequestURL }) {
+				continue
+			}
+			bot.fillMetadata(ctx, &amputations[i], logger)
+			richEmbeds = append(richEmbeds, richEmbed(amputations[i], language))
+		}
+	}
+
+	var replyParts []string
+	if !duplicate && reply == nil {
+		logger.Debug("sending amputate message response")
+		stopTyping()
+		if richReply {
+			components := amputationComponents(ampEventUUID, language, false, false)
+			// The buttons are on the last message, but deleting the reply
+			// has to remove every part of it
+			sent := bot.sendEmbeds(ctx, s, m.Message, richEmbeds, components, logger)
+			for i, part := range sent {
+				if i == len(sent)-1 {
+					reply = part
+				} else {
+					replyParts = append(replyParts, part.ID)
+				}
+			}
+		} else {
+			components := amputationComponents(ampEventUUID, language, false, true)
+			reply = bot.sendMessageWithComponents(ctx, s, ServerConfig.UseEmbed, ServerConfig.ReplyToOriginalMessage,
+				m.Message, embed, components, bot.replyFlags(ctx, s, ServerConfig, m.Message, logger), logger)
+		}
+
+		// The original's previews are only hidden once there's a reply
+		if reply != nil && ServerConfig.SuppressOriginalEmbeds && m.GuildID != "" {
+			bot.suppressEmbeds(ctx, s, m.Message, logger)
+		}
+	}
+	replyMessageId := ""
+	if reply != nil {
+		replyMessageId = reply.ID
+		bot.rememberAmputations(m.Message, resolved, reply)
+	}
+
+	// Create a call to Amputator API event
+	tx := bot.DB.WithContext(ctx).Create(&AmputationEvent{
+		UUID:           ampEventUUID,
+		AuthorId:       m.Author.ID,
+		AuthorUsername: m.Author.Username,
+		ChannelId:      m.ChannelID,
+		MessageId:      m.ID,
+		ServerID:       guild.ID,
+		ReplyMessageId: replyMessageId,
+		ReplyPartIds:   strings.Join(replyParts, " "),
+		Amputations:    amputations,
+	})
+
+	if tx.RowsAffected != 1 {
+		err := fmt.Errorf("unexpected number of rows affected inserting amputation event: %v", tx.RowsAffected)
+		span.SetStatus(codes.Error, err.Error())
+		return err
+	}
+
+	return nil
+}
+
+// replyFlags returns the flags for a plain text reply, which hide its
+// previews unless the server wants them.
+func (bot *AmputatorBot) replyFlags(ctx context.Context, s Session, sc ServerConfig, m *discordgo.Message,
+	logger *log.Entry) discordgo.MessageFlags {
+	if sc.UseEmbed {
+		return 0
+	}
+	if !sc.ReplyPreviews {
+		return discordgo.MessageFlagsSuppressEmbeds
+	}
+	if m.GuildID != "" {
+		if missing, err := missingPermissions(ctx, s, m.ChannelID, discordgo.PermissionEmbedLinks); err != nil {
+			logger.WithError(err).Warn("unable to check permission to show previews")
+		} else if missing != 0 {
+			bot.reportPermissionProblem(ctx, s, m.GuildID,
+				fmt.Sprintf("reply previews in <#%v> were skipped, the bot is missing the Embed Links permission", m.ChannelID), logger)
+		}
+	}
+	return 0
+}
+
+// suppressEmbeds hides the previews on a message, such as the AMP
+// previews on a message that was just replied to.
+func (bot *AmputatorBot) suppressEmbeds(ctx context.Context, s Session, m *discordgo.Message, logger *log.Entry) {
+	missing, err := missingPermissions(ctx, s, m.ChannelID, discordgo.PermissionManageMessages)
+	if err != nil {
+		logger.WithError(err).Warn("unable to check permission to hide previews")
+		return
+	}
+	if missing != 0 {
+		bot.reportPermissionProblem(ctx, s, m.GuildID,
+			fmt.Sprintf("hiding previews in <#%v> was skipped, the bot is missing the Manage Messages permission", m.ChannelID), logger)
+		return
+	}
+
+	// Only the flags of someone else's message can be changed
+	edit := discordgo.NewMessageEdit(m.ChannelID, m.ID)
+	edit.Flags = m.Flags | discordgo.MessageFlagsSuppressEmbeds
+	if _, err := s.ChannelMessageEditComplex(edit, discordgo.WithContext(ctx)); err != nil {
+		logger.WithError(err).Warn("unable to hide previews on original message")
+	}
+}
+
+// amputateLinks finds the canonical URL for each link, from an override,
+// the cache, a rewrite rule or the resolvers in that order. Links that
+// couldn't be amputated are returned without a ResponseURL.
+func (bot *AmputatorBot) amputateLinks(ctx context.Context, links []messageLink, sc ServerConfig, serverID string,
+	ampEventUUID string, logger *log.Entry) ([]Amputation, []string) {
+	resolveCtx, resolveSpan := tracer.Start(ctx, "resolveURLs")
+	defer resolveSpan.End()
 
 	var amputations []Amputation
-	for _, url := range urls {
+	for _, link := range links {
+		url := link.URL
+		urlLogger := logger.WithField("url", url)
 		domainName, err := getDomainName(url)
 		if err != nil {
-			log.Error("unable to get domain name for url: ", url)
+			urlLogger.WithError(err).Error("unable to get domain name for url")
+		}
+
+		// Overrides from reviewed reports win over the cache and resolvers
+		var override URLOverride
+		bot.DB.WithContext(resolveCtx).Where(&URLOverride{RequestURLHash: newURLOverride(url).RequestURLHash}).Find(&override)
+		if override.RequestURL != "" {
+			if override.ResponseURL == "" {
+				urlLogger.Info("url was marked unresolvable")
+				continue
+			}
+			urlLogger.Debug("url has an override")
+			responseDomainName, err := getDomainName(override.ResponseURL)
+			if err != nil {
+				urlLogger.WithError(err).Error("unable to get domain name for override url")
+			}
+			amputations = append(amputations, Amputation{
+				UUID:                uuid.New().String(),
+				AmputationEventUUID: ampEventUUID,
+				ServerID:            serverID,
+				RequestURL:          url,
+				RequestDomainName:   domainName,
+				Label:               link.Label,
+				Suppressed:          link.Suppressed,
+				Spoiler:             link.Spoiler,
+				ResponseURL:         override.ResponseURL,
+				ResponseDomainName:  responseDomainName,
+				Cached:              true,
+				Resolver:            overrideResolverName,
+			})
+			continue
 		}
 
-		// See if there is a response URL for a given request URL in the database.
+		// See if there is a response URL for a given request URL in the
+		// database. Rewrite rules differ between servers and can be
+		// removed, so their results aren't reused. They're cheap to run
+		// again anyway.
 		cachedAmputations := []Amputation{}
-		bot.DB.Model(&Amputation{}).Where(&Amputation{RequestURL: url, Cached: false}).Find(&cachedAmputations)
-		var responseUrl, responseDomainName string
+		bot.DB.WithContext(resolveCtx).Model(&Amputation{}).
+			Where("request_url = ? AND resolver <> ?", url, rewriteRuleResolverName).Find(&cachedAmputations)
+		var cached Amputation
 
 		// If we have a response, create a new Amputation with it,
-		// marking it as cached.
+		// marking it as cached. Prefer responses with metadata.
 		for _, cachedAmputation := range cachedAmputations {
-			if cachedAmputation.ResponseURL != "" && cachedAmputation.ResponseDomainName != "" {
-				responseUrl = cachedAmputation.ResponseURL
-				responseDomainName = cachedAmputation.ResponseDomainName
+			if cachedAmputation.ResponseURL != "" && cachedAmputation.ResponseDomainName != "" &&
+				(cached.Title == "" || cachedAmputation.Title != "") {
+				cached = cachedAmputation
 			}
 		}
 
-		if responseUrl != "" && responseDomainName != "" {
-			log.Debug("url was already cached: ", url)
+		if cached.ResponseURL != "" {
+			urlLogger.Debug("url was already cached")
 			// We have already amputated this URL, so save the response
+			// along with any metadata we found for it.
 			amputations = append(amputations, Amputation{
 				UUID:                uuid.New().String(),
 				AmputationEventUUID: ampEventUUID,
-				ServerID:            guild.ID,
+				ServerID:            serverID,
 				RequestURL:          url,
 				RequestDomainName:   domainName,
-				ResponseURL:         responseUrl,
-				ResponseDomainName:  responseDomainName,
+				Label:               link.Label,
+				Suppressed:          link.Suppressed,
+				Spoiler:             link.Spoiler,
+				ResponseURL:         cached.ResponseURL,
+				ResponseDomainName:  cached.ResponseDomainName,
 				Cached:              true,
+				Resolver:            cached.Resolver,
+				RewriteRule:         cached.RewriteRule,
+				Title:               cached.Title,
+				Publisher:           cached.Publisher,
+				ImageURL:            cached.ImageURL,
+				FaviconURL:          cached.FaviconURL,
+				PublishedAt:         cached.PublishedAt,
 			})
 			continue
 		}
 
 		// We have not already amputated this URL, so build an object
 		// for doing so.
-		log.Debug("url was not cached: ", url)
+		urlLogger.Debug("url was not cached")
 		amputations = append(amputations, Amputation{
 			UUID:                uuid.New().String(),
 			AmputationEventUUID: ampEventUUID,
-			ServerID:            guild.ID,
+			ServerID:            serverID,
 			RequestURL:          url,
 			RequestDomainName:   domainName,
 			Cached:              false,
+			Label:               link.Label,
+			Suppressed:          link.Suppressed,
+			Spoiler:             link.Spoiler,
 		})
 	}
 
 	var amputatedLinks []string
 	for i, amputation := range amputations {
 		if amputation.ResponseURL == "" {
-			log.Debug("need to call amputator api for ", amputation.RequestURL)
-			amputatedUrls, err := goamputate.Amputate([]string{amputation.RequestURL}, map[string]string{
-				"gac": fmt.Sprintf("%v", ServerConfig.GuessAndCheck),
-				"md":  fmt.Sprintf("%v", ServerConfig.MaxDepth),
-			})
-			if err != nil {
-				log.Error("error calling amputator api: ", err)
-				continue
-			}
-			if !(len(amputatedUrls) == 1) {
-				log.Errorf("received %v urls from goamputate, expected 1", len(amputatedUrls))
-				continue
+			urlLogger := logger.WithField("url", amputation.RequestURL)
+			urlLogger.Debug("need to resolve url")
+
+			// Rewrite rules don't need any HTTP calls, so they go first
+			responseURL, rule, ok := bot.rewriteURL(resolveCtx, amputation.RequestURL, serverID)
+			resolver := rewriteRuleResolverName
+			if ok {
+				urlLogger.WithField("rule", rule.Name).Debug("url was rewritten by a rule")
+				amputations[i].RewriteRule = rule.Name
+			} else {
+				var err error
+				responseURL, resolver, err = bot.resolveURL(resolveCtx, amputation.RequestURL, sc, urlLogger)
+				if err != nil {
+					urlLogger.WithError(err).Error("unable to resolve url")
+					amputations[i].Failure = err.Error()
+					continue
+				}
 			}
-			domainName, err := getDomainName(amputatedUrls[0])
+			domainName, err := getDomainName(responseURL)
 			if err != nil {
-				log.Errorf("unable to get domain name for url: %v", amputation.ResponseURL)
+				urlLogger.WithError(err).Error("unable to get domain name for response url")
 			}
-			amputations[i].ResponseURL = amputatedUrls[0]
+			amputations[i].ResponseURL = responseURL
 			amputations[i].ResponseDomainName = domainName
-			amputatedLinks = append(amputatedLinks, amputatedUrls[0])
+			amputations[i].Resolver = resolver
+			amputatedLinks = append(amputatedLinks, responseURL)
 			continue
 		}
 		// We have a response URL, so add that to the links to be used
 		// in the message.
 		amputatedLinks = append(amputatedLinks, amputation.ResponseURL)
 	}
+	resolveSpan.SetAttributes(attribute.Int("amputation.links", len(amputatedLinks)))
 
-	plural := ""
-	if len(amputatedLinks) > 1 {
-		plural = "s"
-	}
-	title := fmt.Sprintf("Amputated Link%v", plural)
+	return amputations, amputatedLinks
+}
 
-	embed := &discordgo.MessageEmbed{
-		Title:       title,
-		Description: strings.Join(amputatedLinks, "\n"),
+// fillMetadata fetches the article metadata for an amputation's canonical
+// URL unless it already has some, from the cache or otherwise.
+func (bot *AmputatorBot) fillMetadata(ctx context.Context, amputation *Amputation, logger *log.Entry) {
+	if amputation.Title != "" {
+		return
 	}
-
-	log.Debug("sending amputate message response in ",
-		guild.Name, "(", m.GuildID, "), calling user: ",
-		m.Author.Username, "(", m.Author.ID, ")")
-	typingStop <- true
-	bot.sendMessage(s, ServerConfig.UseEmbed, ServerConfig.ReplyToOriginalMessage, m.Message, embed)
-
-	// Create a call to Amputator API event
-	tx := bot.DB.Create(&AmputationEvent{
-		UUID:           ampEventUUID,
-		AuthorId:       m.Author.ID,
-		AuthorUsername: m.Author.Username,
-		ChannelId:      m.ChannelID,
-		MessageId:      m.ID,
-		ServerID:       guild.ID,
-		Amputations:    amputations,
-	})
-
-	if tx.RowsAffected != 1 {
-		return fmt.Errorf("unexpected number of rows affected inserting amputation event: %v", tx.RowsAffected)
+	metadata, err := fetchPageMetadata(ctx, amputation.ResponseURL)
+	if err != nil {
+		logger.WithError(err).WithField("url", amputation.ResponseURL).Debug("unable to fetch page metadata")
+		return
 	}
-
-	return nil
+	amputation.Title = metadata.Title
+	amputation.Publisher = metadata.Publisher
+	amputation.ImageURL = metadata.ImageURL
+	amputation.FaviconURL = metadata.FaviconURL
+	amputation.PublishedAt = metadata.PublishedAt
 }
@@ -0,0 +1,263 @@
+package bot
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"golang.org/x/net/html"
+)
+
+// pageMetadata is what's shown about an article in the rich reply layout.
+type pageMetadata struct {
+	Title       string
+	Publisher   string
+	ImageURL    string
+	FaviconURL  string
+	PublishedAt *time.Time
+}
+
+// fetchPageMetadata fetches a page and reads its OpenGraph and JSON-LD
+// metadata. JSON-LD wins where both are present since it's usually more
+// specific about the article.
+func fetchPageMetadata(ctx context.Context, pageURL string) (pageMetadata, error) {
+	ctx, span := tracer.Start(ctx, "fetchPageMetadata")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, defaultAPITimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, pageURL, nil)
+	if err != nil {
+		return pageMetadata{}, err
+	}
+	req.Header.Add("User-Agent", amputatorUserAgent)
+
+	res, err := pageHTTPClient.Do(req)
+	if err != nil {
+		return pageMetadata{}, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return pageMetadata{}, fmt.Errorf("page returned status %v", res.StatusCode)
+	}
+
+	metadata := parsePageMetadata(io.LimitReader(res.Body, maxPageSize), res.Request.URL)
+	if metadata.Title == "" {
+		return metadata, errors.New("no metadata found")
+	}
+	return metadata, nil
+}
+
+// parsePageMetadata reads metadata from an HTML document. Relative URLs
+// are resolved against base.
+func parsePageMetadata(r io.Reader, base *url.URL) pageMetadata {
+	var og, ld pageMetadata
+	var title string
+	inTitle, inJSONLD := false, false
+
+	tokenizer := html.NewTokenizer(r)
+	for {
+		tokenType := tokenizer.Next()
+		if tokenType == html.ErrorToken {
+			break
+		}
+		token := tokenizer.Token()
+
+		switch tokenType {
+		case html.StartTagToken, html.SelfClosingTagToken:
+			attrs := map[string]string{}
+			for _, attr := range token.Attr {
+				attrs[strings.ToLower(attr.Key)] = attr.Val
+			}
+			switch token.Data {
+			case "title":
+				inTitle = true
+			case "script":
+				inJSONLD = strings.EqualFold(attrs["type"], "application/ld+json")
+			case "meta":
+				property := attrs["property"]
+				if property == "" {
+					property = attrs["name"]
+				}
+				switch strings.ToLower(property) {
+				case "og:title":
+					og.Title = attrs["content"]
+				case "og:site_name":
+					og.Publisher = attrs["content"]
+				case "og:image", "twitter:image":
+					if og.ImageURL == "" {
+						og.ImageURL = attrs["content"]
+					}
+				case "article:published_time":
+					og.PublishedAt = parseTime(attrs["content"])
+				}
+			case "link":
+				for _, rel := range strings.Fields(strings.ToLower(attrs["rel"])) {
+					if rel == "icon" && og.FaviconURL == "" {
+						og.FaviconURL = attrs["href"]
+					}
+				}
+			}
+		case html.EndTagToken:
+			inTitle, inJSONLD = false, false
+		case html.TextToken:
+			if inTitle && title == "" {
+				title = strings.TrimSpace(token.Data)
+			}
+			if inJSONLD {
+				mergeMetadata(&ld, parseJSONLD([]byte(token.Data)))
+			}
+		}
+	}
+
+	metadata := ld
+	mergeMetadata(&metadata, og)
+	if metadata.Title == "" {
+		metadata.Title = title
+	}
+	if metadata.FaviconURL == "" {
+		metadata.FaviconURL = "/favicon.ico"
+	}
+	metadata.ImageURL = resolveReference(base, metadata.ImageURL)
+	metadata.FaviconURL = resolveReference(base, metadata.FaviconURL)
+	return metadata
+}
+
+// parseJSONLD reads article metadata from a JSON-LD block, which may be a
+// single object, a list of objects or an object with an @graph.
+func parseJSONLD(data []byte) pageMetadata {
+	var raw any
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return pageMetadata{}
+	}
+
+	var metadata pageMetadata
+	var walk func(v any)
+	walk = func(v any) {
+		switch v := v.(type) {
+		case []any:
+			for _, item := range v {
+				walk(item)
+			}
+		case map[string]any:
+			if graph, ok := v["@graph"]; ok {
+				walk(graph)
+			}
+			if metadata.Title == "" {
+				metadata.Title = jsonString(v["headline"])
+			}
+			if publisher, ok := v["publisher"].(map[string]any); ok && metadata.Publisher == "" {
+				metadata.Publisher = jsonString(publisher["name"])
+			}
+			if metadata.ImageURL == "" {
+				metadata.ImageURL = jsonString(v["image"])
+			}
+			if metadata.PublishedAt == nil {
+				metadata.PublishedAt = parseTime(jsonString(v["datePublished"]))
+			}
+		}
+	}
+	walk(raw)
+	return metadata
+}
+
+// jsonString returns a string from a JSON-LD value, which may be a plain
+// string, an object with a url or a list of either.
+func jsonString(v any) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case map[string]any:
+		return jsonString(v["url"])
+	case []any:
+		if len(v) > 0 {
+			return jsonString(v[0])
+		}
+	}
+	return ""
+}
+
+// mergeMetadata fills in any fields of dst that are empty from src.
+func mergeMetadata(dst *pageMetadata, src pageMetadata) {
+	if dst.Title == "" {
+		dst.Title = src.Title
+	}
+	if dst.Publisher == "" {
+		dst.Publisher = src.Publisher
+	}
+	if dst.ImageURL == "" {
+		dst.ImageURL = src.ImageURL
+	}
+	if dst.FaviconURL == "" {
+		dst.FaviconURL = src.FaviconURL
+	}
+	if dst.PublishedAt == nil {
+		dst.PublishedAt = src.PublishedAt
+	}
+}
+
+// parseTime parses the timestamp formats publishers commonly use.
+func parseTime(s string) *time.Time {
+	for _, layout := range []string{time.RFC3339, "2006-01-02T15:04:05Z0700", "2006-01-02T15:04:05", "2006-01-02"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return &t
+		}
+	}
+	return nil
+}
+
+// resolveReference resolves a possibly relative URL against base.
+func resolveReference(base *url.URL, ref string) string {
+	if ref == "" || base == nil {
+		return ref
+	}
+	resolved, err := base.Parse(ref)
+	if err != nil {
+		return ""
+	}
+	return resolved.String()
+}
+
+// truncateText shortens text to at most limit characters, ending it with
+// an ellipsis if it was cut.
+func truncateText(text string, limit int) string {
+	if runes := []rune(text); len(runes) > limit {
+		return string(runes[:limit-3]) + "..."
+	}
+	return text
+}
+
+// richEmbed builds the rich layout embed for a single amputated link.
+// Discord rejects the whole message if the title or author name are too
+// long, so they're truncated.
+func richEmbed(a Amputation, language string) *discordgo.MessageEmbed {
+	title := a.Title
+	if title == "" {
+		title = a.ResponseURL
+	}
+
+	embed := &discordgo.MessageEmbed{
+		Title: truncateText(title, maxEmbedTitleLength),
+		URL:   a.ResponseURL,
+		Footer: &discordgo.MessageEmbedFooter{
+			Text: translate(language, "Amputated from %v", a.RequestDomainName),
+		},
+	}
+	if a.Publisher != "" {
+		embed.Author = &discordgo.MessageEmbedAuthor{Name: truncateText(a.Publisher, maxEmbedAuthorLength), IconURL: a.FaviconURL}
+	}
+	if a.ImageURL != "" {
+		embed.Thumbnail = &discordgo.MessageEmbedThumbnail{URL: a.ImageURL}
+	}
+	if a.PublishedAt != nil {
+		embed.Timestamp = a.PublishedAt.Format(time.RFC3339)
+	}
+	return embed
+}
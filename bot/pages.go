@@ -0,0 +1,98 @@
+package bot
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"syscall"
+	"time"
+)
+
+const (
+	// maxPageRedirects is the most redirects followed fetching a page.
+	maxPageRedirects int = 5
+
+	pageDialTimeout time.Duration = time.Second * 5
+)
+
+var (
+	// allowPrivateAddresses lets pages be fetched from private addresses,
+	// so tests can serve pages locally.
+	allowPrivateAddresses bool
+
+	// nonPublicPrefixes are special-purpose ranges netip doesn't already
+	// know aren't public.
+	nonPublicPrefixes = []netip.Prefix{
+		netip.MustParsePrefix("0.0.0.0/8"),
+		netip.MustParsePrefix("100.64.0.0/10"),
+		netip.MustParsePrefix("192.0.0.0/24"),
+		netip.MustParsePrefix("198.18.0.0/15"),
+		netip.MustParsePrefix("240.0.0.0/4"),
+		netip.MustParsePrefix("64:ff9b::/96"),
+		netip.MustParsePrefix("64:ff9b:1::/48"),
+	}
+
+	errNonPublicAddress = errors.New("refusing to fetch a page from a non-public address")
+)
+
+// pageHTTPClient fetches pages posted in messages, to read their canonical
+// link or metadata. Those URLs come from anyone, so it only connects to
+// public addresses. That's checked when dialing, after DNS is resolved, so
+// it covers every redirect and hostnames pointing at private addresses.
+var pageHTTPClient = TracedHTTPClient(&http.Client{
+	Transport: &http.Transport{
+		DialContext: (&net.Dialer{
+			Timeout: pageDialTimeout,
+			Control: dialPublicOnly,
+		}).DialContext,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          100,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+	},
+	CheckRedirect: checkPageRedirect,
+})
+
+// publicAddress reports whether an address is on the public internet.
+func publicAddress(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	if !addr.IsGlobalUnicast() || addr.IsPrivate() {
+		return false
+	}
+	for _, prefix := range nonPublicPrefixes {
+		if prefix.Contains(addr) {
+			return false
+		}
+	}
+	return true
+}
+
+// dialPublicOnly refuses connections to anything but public addresses.
+func dialPublicOnly(network, address string, _ syscall.RawConn) error {
+	if allowPrivateAddresses {
+		return nil
+	}
+	addrPort, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return fmt.Errorf("unable to parse address %v: %w", address, err)
+	}
+	if !publicAddress(addrPort.Addr()) {
+		return fmt.Errorf("%w: %v", errNonPublicAddress, addrPort.Addr())
+	}
+	return nil
+}
+
+// checkPageRedirect stops following redirects after maxPageRedirects, or
+// when one leaves http and https.
+func checkPageRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) > maxPageRedirects {
+		return fmt.Errorf("stopped after %v redirects", maxPageRedirects)
+	}
+	if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+		return fmt.Errorf("refusing to follow a redirect to %v", req.URL.Scheme)
+	}
+	return nil
+}
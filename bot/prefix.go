@@ -0,0 +1,81 @@
+package bot
+
+import (
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// A prefixCache keeps each server's command prefix, so the config doesn't
+// have to be looked up for every message to tell if it's a command.
+type prefixCache struct {
+	mu      sync.Mutex
+	byGuild map[string]string
+}
+
+func (c *prefixCache) get(guildID string) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	prefix, ok := c.byGuild[guildID]
+	return prefix, ok
+}
+
+func (c *prefixCache) set(guildID string, prefix string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.byGuild[guildID] = prefix
+}
+
+func (c *prefixCache) forget(guildID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.byGuild, guildID)
+}
+
+func newPrefixCache() *prefixCache {
+	return &prefixCache{byGuild: map[string]string{}}
+}
+
+// serverPrefix returns the command prefix for a server, or the default
+// prefix for DMs.
+func (bot *AmputatorBot) serverPrefix(guildID string) string {
+	if guildID == "" {
+		return commandPrefix
+	}
+	cache := bot.prefixes
+	if prefix, ok := cache.get(guildID); ok {
+		return prefix
+	}
+	prefix := bot.getServerConfig(guildID).CommandPrefix
+	if prefix == "" {
+		prefix = commandPrefix
+	}
+	cache.set(guildID, prefix)
+	return prefix
+}
+
+// commandMessage reports whether a message is a command, which starts with
+// the server's prefix or a mention of the bot. Mentions work everywhere so
+// a server that forgets its prefix can still reach the bot. The returned
+// copy of the message starts with the default prefix instead, so command
+// handlers don't need to know which one was used.
+func (bot *AmputatorBot) commandMessage(s Session, m *discordgo.Message) (*discordgo.Message, bool) {
+	prefixes := []string{bot.serverPrefix(m.GuildID)}
+	if user := s.BotUser(); user != nil {
+		prefixes = append(prefixes, "<@"+user.ID+">", "<@!"+user.ID+">")
+	}
+	for _, prefix := range prefixes {
+		// The prefix has to be a word of its own, so "!amazing" isn't
+		// taken for a command with the prefix "!a"
+		rest, ok := strings.CutPrefix(m.Content, prefix)
+		if !ok || (rest != "" && !unicode.IsSpace([]rune(rest)[0])) {
+			continue
+		}
+		command := *m
+		command.Content = strings.TrimSpace(commandPrefix + " " + strings.TrimSpace(rest))
+		return &command, true
+	}
+	return nil, false
+}
@@ -0,0 +1,269 @@
+package bot
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// replaceWebhookName is the name of the webhooks the bot creates to
+	// repost messages through.
+	replaceWebhookName string = "Amputator"
+
+	// replacePermissions are the permissions the bot needs in a channel to
+	// replace messages.
+	replacePermissions int64 = discordgo.PermissionManageWebhooks | discordgo.PermissionManageMessages
+
+	// maxReplaceAttachmentSize is how much the attachments of a replaced
+	// message can add up to, which is Discord's upload limit for servers
+	// without boosts.
+	maxReplaceAttachmentSize int = 10 << 20
+
+	// attachmentDownloadTimeout is how long downloading all the
+	// attachments of a replaced message can take.
+	attachmentDownloadTimeout time.Duration = time.Second * 30
+
+	// maxReplaceAge keeps old messages, such as those from scans, from
+	// being reposted out of order.
+	maxReplaceAge time.Duration = time.Minute * 10
+
+	maxMessageLength int = 2000
+)
+
+// A webhookCache keeps the webhook the bot reposts through in each channel,
+// along with the IDs of every webhook it has used so it can ignore its own
+// reposts.
+type webhookCache struct {
+	mu        sync.Mutex
+	byChannel map[string]*discordgo.Webhook
+	owned     map[string]bool
+}
+
+func (c *webhookCache) get(channelID string) *discordgo.Webhook {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.byChannel[channelID]
+}
+
+func (c *webhookCache) add(channelID string, webhook *discordgo.Webhook) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.byChannel[channelID] = webhook
+	c.owned[webhook.ID] = true
+}
+
+// forget drops the webhook for a channel, so it's looked up again next
+// time. It's still known as the bot's own.
+func (c *webhookCache) forget(channelID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.byChannel, channelID)
+}
+
+func (c *webhookCache) owns(webhookID string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.owned[webhookID]
+}
+
+func newWebhookCache() *webhookCache {
+	return &webhookCache{
+		byChannel: map[string]*discordgo.Webhook{},
+		owned:     map[string]bool{},
+	}
+}
+
+// isOwnRepost reports whether a message is one the bot reposted in
+// replace mode, so it isn't handled again.
+func (bot *AmputatorBot) isOwnRepost(m *discordgo.Message) bool {
+	if m.WebhookID == "" {
+		return false
+	}
+	if bot.webhooks.owns(m.WebhookID) {
+		return true
+	}
+
+	// Reposts from before a restart are only known from their events
+	var reposts int64
+	bot.DB.Model(&AmputationEvent{}).Where(&AmputationEvent{ReplyMessageId: m.ID}).Count(&reposts)
+	return reposts > 0
+}
+
+// channelWebhook returns the webhook the bot reposts through in a channel,
+// creating it if there isn't one yet.
+func (bot *AmputatorBot) channelWebhook(ctx context.Context, s Session, channelID string) (*discordgo.Webhook, error) {
+	cache := bot.webhooks
+	if webhook := cache.get(channelID); webhook != nil {
+		return webhook, nil
+	}
+
+	webhooks, err := s.ChannelWebhooks(channelID, discordgo.WithContext(ctx))
+	if err != nil {
+		return nil, fmt.Errorf("unable to list webhooks: %w", err)
+	}
+	var webhook *discordgo.Webhook
+	for _, w := range webhooks {
+		// Only the webhooks the bot created come with a token
+		if w.User != nil && s.BotUser() != nil && w.User.ID == s.BotUser().ID && w.Token != "" {
+			webhook = w
+			break
+		}
+	}
+	if webhook == nil {
+		webhook, err = s.WebhookCreate(channelID, replaceWebhookName, "", discordgo.WithContext(ctx))
+		if err != nil {
+			return nil, fmt.Errorf("unable to create webhook: %w", err)
+		}
+	}
+	cache.add(channelID, webhook)
+	return webhook, nil
+}
+
+// replaceMessage reposts a message through a webhook under its author's
+// name and avatar with its AMP links amputated, then deletes the original.
+// The repost is returned, or an error if the message can't be replaced and
+// should be replied to instead.
+func (bot *AmputatorBot) replaceMessage(ctx context.Context, s Session, m *discordgo.Message,
+	amputations []Amputation, logger *log.Entry) (*discordgo.Message, error) {
+	ctx, span := tracer.Start(ctx, "replaceMessage")
+	defer span.End()
+
+	if time.Since(m.Timestamp) > maxReplaceAge {
+		return nil, fmt.Errorf("message is too old to replace")
+	}
+	if len(m.StickerItems) > 0 || m.Poll != nil {
+		return nil, fmt.Errorf("messages with stickers or polls can't be reposted")
+	}
+	missing, err := missingPermissions(ctx, s, m.ChannelID, replacePermissions)
+	if err != nil {
+		return nil, err
+	}
+	if missing != 0 {
+		bot.reportPermissionProblem(ctx, s, m.GuildID, fmt.Sprintf("replacing a message in <#%v> was skipped, "+
+			"the bot is missing the Manage Webhooks or Manage Messages permission", m.ChannelID), logger)
+		return nil, fmt.Errorf("missing the Manage Webhooks or Manage Messages permission")
+	}
+
+	// Links only in embeds or forwarded messages can't be changed
+	content := m.Content
+	for _, amputation := range amputations {
+		if amputation.ResponseURL != "" {
+			content = strings.ReplaceAll(content, amputation.RequestURL, amputation.ResponseURL)
+		}
+	}
+	if content == m.Content {
+		return nil, fmt.Errorf("none of the amputated links are in the message text")
+	}
+
+	// Webhooks can't reply, so replies link to the message instead
+	if m.MessageReference != nil && m.MessageReference.MessageID != "" {
+		language := responseLanguage(bot.getMessageConfig(m), "")
+		messageURL := fmt.Sprintf("https://discord.com/channels/%v/%v/%v",
+			m.GuildID, m.MessageReference.ChannelID, m.MessageReference.MessageID)
+		content = "-# ↪ " + translate(language, "Replying to %v", messageURL) + "\n" + content
+	}
+	if len([]rune(content)) > maxMessageLength {
+		return nil, fmt.Errorf("amputated message is too long to repost")
+	}
+
+	files, err := downloadAttachments(ctx, m.Attachments)
+	if err != nil {
+		return nil, err
+	}
+
+	webhook, err := bot.channelWebhook(ctx, s, m.ChannelID)
+	if err != nil {
+		return nil, err
+	}
+	username, avatarURL := repostIdentity(m)
+	repost, err := s.WebhookExecute(webhook.ID, webhook.Token, true, &discordgo.WebhookParams{
+		Content:   content,
+		Username:  username,
+		AvatarURL: avatarURL,
+		Files:     files,
+		// Anyone mentioned was already notified by the original
+		AllowedMentions: &discordgo.MessageAllowedMentions{},
+	}, discordgo.WithContext(ctx))
+	if err != nil {
+		// The webhook may have been deleted, so it's looked up again next time
+		bot.webhooks.forget(m.ChannelID)
+		return nil, fmt.Errorf("unable to repost message: %w", err)
+	}
+
+	// The original and the repost shouldn't both be left in the channel
+	if err := s.ChannelMessageDelete(m.ChannelID, m.ID, discordgo.WithContext(ctx)); err != nil {
+		if undoErr := s.ChannelMessageDelete(repost.ChannelID, repost.ID, discordgo.WithContext(ctx)); undoErr != nil {
+			logger.WithError(undoErr).Error("unable to delete repost after failing to delete the original")
+		}
+		return nil, fmt.Errorf("unable to delete original message: %w", err)
+	}
+	logger.WithField("repost", repost.ID).Info("replaced message")
+	return repost, nil
+}
+
+// repostIdentity returns the name and avatar to repost a message under,
+// preferring the author's server nickname and avatar.
+func repostIdentity(m *discordgo.Message) (string, string) {
+	if m.Member == nil {
+		return m.Author.DisplayName(), m.Author.AvatarURL("")
+	}
+	member := *m.Member
+	member.GuildID = m.GuildID
+	member.User = m.Author
+	return member.DisplayName(), member.AvatarURL("")
+}
+
+// downloadAttachments downloads a message's attachments so they can be
+// uploaded with its repost.
+func downloadAttachments(ctx context.Context, attachments []*discordgo.MessageAttachment) ([]*discordgo.File, error) {
+	total := 0
+	for _, attachment := range attachments {
+		total += attachment.Size
+	}
+	if total > maxReplaceAttachmentSize {
+		return nil, fmt.Errorf("attachments are too large to repost: %v bytes", total)
+	}
+
+	// The sizes come from Discord, but the downloads are capped too in
+	// case they don't match
+	ctx, cancel := context.WithTimeout(ctx, attachmentDownloadTimeout)
+	defer cancel()
+	remaining := int64(maxReplaceAttachmentSize)
+	var files []*discordgo.File
+	for _, attachment := range attachments {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, attachment.URL, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Add("User-Agent", amputatorUserAgent)
+
+		res, err := amputatorHTTPClient.Do(req)
+		if err != nil {
+			return nil, fmt.Errorf("unable to download attachment %v: %w", attachment.Filename, err)
+		}
+		body, err := io.ReadAll(io.LimitReader(res.Body, remaining+1))
+		res.Body.Close()
+		if err != nil || res.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("unable to download attachment %v: status %v, %v", attachment.Filename, res.StatusCode, err)
+		}
+		remaining -= int64(len(body))
+		if remaining < 0 {
+			return nil, fmt.Errorf("attachments are too large to repost: more than %v bytes", maxReplaceAttachmentSize)
+		}
+		files = append(files, &discordgo.File{
+			Name:        attachment.Filename,
+			ContentType: attachment.ContentType,
+			Reader:      bytes.NewReader(body),
+		})
+	}
+	return files, nil
+}
@@ -0,0 +1,187 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	pendingReport      string = "pending"
+	acceptedReport     string = "accepted"
+	unresolvableReport string = "unresolvable"
+	dismissedReport    string = "dismissed"
+
+	// Review actions. Marking a URL unresolvable is unresolvableReport.
+	acceptReview  string = "accept"
+	dismissReview string = "dismiss"
+
+	// maxReviewQueueLength is how many reports are listed at once, since
+	// each one is an embed field.
+	maxReviewQueueLength int = 10
+)
+
+// isAdmin reports whether a user is one of the bot admins from AdminIds.
+func (bot *AmputatorBot) isAdmin(userID string) bool {
+	for _, id := range bot.Config.AdminIds {
+		if userID == id {
+			return true
+		}
+	}
+	return false
+}
+
+// handleReviewCommand lets bot admins work through reports of wrong links.
+// Syntax:
+// (commandPrefix) review
+// (commandPrefix) review accept (report) (original url) (corrected url)
+// (commandPrefix) review unresolvable (report) (original url)
+// (commandPrefix) review dismiss (report)
+func (bot *AmputatorBot) handleReviewCommand(ctx context.Context, s Session, m *discordgo.Message, logger *log.Entry) error {
+	if !bot.isAdmin(m.Author.ID) {
+		return fmt.Errorf("did not respond to %v(%v), command %v because user is not an administrator",
+			m.Author.Username, m.Author.ID, reviewCommand)
+	}
+	language := responseLanguage(bot.getMessageConfig(m), "")
+
+	words := strings.Fields(m.Content)
+	if len(words) == 2 {
+		// Discord allows 6000 characters per message, so each embed is sent
+		// on its own
+		for _, embed := range bot.reviewQueueEmbeds(m.GuildID, language) {
+			bot.sendMessage(ctx, s, true, false, m, embed, logger)
+		}
+		return nil
+	}
+
+	usageEmbed := &discordgo.MessageEmbed{
+		Title:       translate(language, "Unable to set %v", reviewCommand),
+		Description: translate(language, "See %v for usage", amputatorRepoUrl),
+	}
+	if len(words) < 4 {
+		bot.sendMessage(ctx, s, true, false, m, usageEmbed, logger)
+		return fmt.Errorf("not enough arguments for review")
+	}
+
+	action, reportUUID := words[2], words[3]
+	var report AmputationReport
+	bot.DB.WithContext(ctx).Where(&AmputationReport{UUID: reportUUID}).Find(&report)
+	if report.UUID == "" || report.Status != pendingReport {
+		bot.sendMessage(ctx, s, true, false, m, usageEmbed, logger)
+		return fmt.Errorf("no pending report with id %v", reportUUID)
+	}
+
+	status := ""
+	var override URLOverride
+	switch {
+	case action == acceptReview && len(words) == 6:
+		status = acceptedReport
+		override = newURLOverride(words[4])
+		override.ResponseURL = words[5]
+		if _, err := getDomainName(override.ResponseURL); err != nil || !strings.HasPrefix(override.ResponseURL, "http") {
+			bot.sendMessage(ctx, s, true, false, m, usageEmbed, logger)
+			return fmt.Errorf("invalid corrected url: %v", override.ResponseURL)
+		}
+	case action == unresolvableReport && len(words) == 5:
+		status = unresolvableReport
+		override = newURLOverride(words[4])
+	case action == dismissReview && len(words) == 4:
+		status = dismissedReport
+	default:
+		bot.sendMessage(ctx, s, true, false, m, usageEmbed, logger)
+		return fmt.Errorf("unknown review action: %v", action)
+	}
+
+	// The original URL has to be one of the links that was reported
+	if override.RequestURL != "" {
+		var amputation Amputation
+		bot.DB.WithContext(ctx).Where(&Amputation{
+			AmputationEventUUID: report.AmputationEventUUID,
+			RequestURL:          override.RequestURL,
+		}).Find(&amputation)
+		if amputation.UUID == "" {
+			bot.sendMessage(ctx, s, true, false, m, usageEmbed, logger)
+			return fmt.Errorf("%v was not part of report %v", override.RequestURL, report.UUID)
+		}
+
+		// Save replaces any earlier override for the same URL
+		override.ReportUUID = report.UUID
+		override.AddedBy = m.Author.ID
+		if tx := bot.DB.WithContext(ctx).Save(&override); tx.Error != nil {
+			return fmt.Errorf("unable to save override: %w", tx.Error)
+		}
+	}
+
+	reviewedAt := time.Now()
+	tx := bot.DB.WithContext(ctx).Model(&AmputationReport{}).Where(&AmputationReport{UUID: report.UUID}).
+		Updates(AmputationReport{Status: status, ReviewedBy: m.Author.ID, ReviewedAt: &reviewedAt})
+	if tx.RowsAffected != 1 {
+		return fmt.Errorf("did not expect %v rows to be affected updating report %v", tx.RowsAffected, report.UUID)
+	}
+
+	logger.WithFields(log.Fields{
+		"report":       report.UUID,
+		"status":       status,
+		"request_url":  override.RequestURL,
+		"response_url": override.ResponseURL,
+	}).Info("report reviewed")
+	bot.sendMessage(ctx, s, true, false, m, &discordgo.MessageEmbed{
+		Title:       translate(language, "Report Reviewed"),
+		Description: translate(language, "%v set to %v", report.UUID, status),
+	}, logger)
+
+	return nil
+}
+
+// reviewQueueEmbeds lists the oldest pending reports along with the links
+// that were reported. In a server only its own reports are listed, in DMs
+// every server's are. Each report is cut to fit in a field and the reports
+// are split across embeds that each fit in a message.
+func (bot *AmputatorBot) reviewQueueEmbeds(guildID string, language string) []*discordgo.MessageEmbed {
+	query := bot.DB.Where(&AmputationReport{Status: pendingReport})
+	if guildID != "" {
+		query = query.Where(&AmputationReport{ServerID: guildID})
+	}
+	var reports []AmputationReport
+	query.Order("created_at").Limit(maxReviewQueueLength).Find(&reports)
+
+	embed := &discordgo.MessageEmbed{Title: translate(language, "Review Queue")}
+	if len(reports) == 0 {
+		embed.Description = translate(language, "No reports to review")
+		return []*discordgo.MessageEmbed{embed}
+	}
+
+	embeds := []*discordgo.MessageEmbed{embed}
+	length := len([]rune(embed.Title))
+	for _, report := range reports {
+		var amputations []Amputation
+		bot.DB.Where(&Amputation{AmputationEventUUID: report.AmputationEventUUID}).Find(&amputations)
+
+		var lines []string
+		for _, amputation := range amputations {
+			lines = append(lines, amputation.RequestURL+" → "+amputation.ResponseURL)
+		}
+		if report.Comment != "" {
+			lines = append(lines, "> "+report.Comment)
+		}
+		lines = append(lines, translate(language, "Reported by %v", report.ReporterUsername))
+
+		field := &discordgo.MessageEmbedField{
+			Name:  report.UUID,
+			Value: truncateText(strings.Join(lines, "\n"), maxEmbedFieldLength),
+		}
+		fieldLength := len([]rune(field.Name)) + len([]rune(field.Value))
+		if length+fieldLength > maxEmbedLength {
+			embed = &discordgo.MessageEmbed{}
+			embeds = append(embeds, embed)
+			length = 0
+		}
+		embed.Fields = append(embed.Fields, field)
+		length += fieldLength
+	}
+	return embeds
+}
@@ -0,0 +1,173 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/net/html"
+)
+
+const (
+	amputatorAPIResolverName  string = "amputator_api"
+	canonicalLinkResolverName string = "canonical_link"
+	overrideResolverName      string = "override"
+
+	// maxPageSize is the most of a page that will be read looking for
+	// its canonical link.
+	maxPageSize int64 = 2 << 20
+)
+
+var errBreakerOpen = errors.New("amputator api circuit breaker is open")
+
+// A urlResolver finds the canonical (non-AMP) URL for a URL.
+type urlResolver interface {
+	Name() string
+	Resolve(ctx context.Context, requestURL string, sc ServerConfig) (string, error)
+}
+
+// resolvers returns the resolvers to try, in order, for URLs that aren't
+// cached.
+func (bot *AmputatorBot) resolvers() []urlResolver {
+	return []urlResolver{
+		amputatorAPIResolver{bot: bot},
+		canonicalLinkResolver{},
+	}
+}
+
+// resolveURL tries each resolver in turn and returns the first canonical
+// URL found along with the name of the resolver that found it.
+func (bot *AmputatorBot) resolveURL(ctx context.Context, requestURL string, sc ServerConfig, logger *log.Entry) (string, string, error) {
+	var errs []error
+	for _, resolver := range bot.resolvers() {
+		responseURL, err := resolver.Resolve(ctx, requestURL, sc)
+		if err != nil {
+			logger.WithError(err).WithField("resolver", resolver.Name()).Debug("resolver did not find a url")
+			errs = append(errs, fmt.Errorf("%v: %w", resolver.Name(), err))
+			continue
+		}
+		return responseURL, resolver.Name(), nil
+	}
+	return "", "", errors.Join(errs...)
+}
+
+// amputatorAPIResolver asks the Amputator API, guarded by the circuit
+// breaker so a struggling upstream is skipped instead of waited on.
+type amputatorAPIResolver struct {
+	bot *AmputatorBot
+}
+
+func (r amputatorAPIResolver) Name() string {
+	return amputatorAPIResolverName
+}
+
+func (r amputatorAPIResolver) Resolve(ctx context.Context, requestURL string, sc ServerConfig) (string, error) {
+	breaker := r.bot.breaker
+	if !breaker.Allow() {
+		return "", errBreakerOpen
+	}
+
+	// Only failures that suggest the API itself is struggling count towards
+	// opening the breaker. A 4xx or malformed reply for one bad URL means
+	// the API is up.
+	urls, err := r.bot.amputateWithRetries(ctx, requestURL, sc)
+	if err != nil && retryable(err) {
+		breaker.Failure()
+		return "", err
+	}
+	breaker.Success()
+	if err != nil {
+		return "", err
+	}
+
+	if len(urls) != 1 {
+		return "", fmt.Errorf("received %v urls from amputator api, expected 1", len(urls))
+	}
+	return urls[0], nil
+}
+
+// canonicalLinkResolver fetches the page itself and reads its
+// <link rel="canonical">, which is what AMP pages are required to have.
+type canonicalLinkResolver struct{}
+
+func (r canonicalLinkResolver) Name() string {
+	return canonicalLinkResolverName
+}
+
+func (r canonicalLinkResolver) Resolve(ctx context.Context, requestURL string, sc ServerConfig) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, defaultAPITimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, requestURL, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Add("User-Agent", amputatorUserAgent)
+
+	res, err := pageHTTPClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("page returned status %v", res.StatusCode)
+	}
+
+	canonical, err := findCanonicalLink(io.LimitReader(res.Body, maxPageSize))
+	if err != nil {
+		return "", err
+	}
+
+	// Relative canonical links are resolved against the final page URL
+	base := res.Request.URL
+	resolved, err := base.Parse(canonical)
+	if err != nil {
+		return "", fmt.Errorf("unable to parse canonical link %v: %w", canonical, err)
+	}
+	if resolved.String() == requestURL {
+		return "", fmt.Errorf("page is its own canonical")
+	}
+	return resolved.String(), nil
+}
+
+// findCanonicalLink returns the href of the first <link rel="canonical">
+// in an HTML document.
+func findCanonicalLink(r io.Reader) (string, error) {
+	tokenizer := html.NewTokenizer(r)
+	for {
+		switch tokenizer.Next() {
+		case html.ErrorToken:
+			if errors.Is(tokenizer.Err(), io.EOF) {
+				return "", fmt.Errorf("no canonical link found")
+			}
+			return "", tokenizer.Err()
+		case html.StartTagToken, html.SelfClosingTagToken:
+			token := tokenizer.Token()
+			if token.Data == "body" {
+				return "", fmt.Errorf("no canonical link found")
+			}
+			if token.Data != "link" {
+				continue
+			}
+			var rel, href string
+			for _, attr := range token.Attr {
+				switch attr.Key {
+				case "rel":
+					rel = attr.Val
+				case "href":
+					href = attr.Val
+				}
+			}
+			if strings.EqualFold(rel, "canonical") && href != "" {
+				if _, err := url.Parse(href); err == nil {
+					return href, nil
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,337 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/google/uuid"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	rewriteRuleResolverName string = "rewrite_rule"
+
+	// anyDomain scopes a rewrite rule to every domain.
+	anyDomain string = "*"
+
+	// maxServerRewriteRules limits how many rules a single server can add.
+	maxServerRewriteRules int = 25
+)
+
+// A RewriteRule turns an AMP URL into its canonical URL without any HTTP
+// calls. Match is a regular expression and Rewrite its replacement, using
+// ${1} style references. Rules with an empty ServerID apply everywhere.
+type RewriteRule struct {
+	CreatedAt time.Time
+	UUID      string `gorm:"primaryKey"`
+	ServerID  string
+	Name      string
+	Domain    string
+	Match     string
+	Rewrite   string
+	AddedBy   string
+
+	// pattern is Match, compiled once when the rule is loaded.
+	pattern *regexp.Regexp
+}
+
+// builtinRewriteRules ship with the bot and run after any rules that were
+// added by servers or admins.
+var builtinRewriteRules = compileRewriteRules([]RewriteRule{
+	{
+		Name:    "amp_path_segment",
+		Domain:  anyDomain,
+		Match:   `(?i)^(https?://[^?#]+?)/amp(/|[?#]|$)`,
+		Rewrite: "${1}${2}",
+	},
+	{
+		Name:    "amp_subdomain",
+		Domain:  anyDomain,
+		Match:   `(?i)^(https?://)amp\.([^/]+\.[^/]+)`,
+		Rewrite: "${1}${2}",
+	},
+	{
+		Name:    "amp_output_type",
+		Domain:  anyDomain,
+		Match:   `(?i)([?&])outputType=amp(&|$)`,
+		Rewrite: "${1}",
+	},
+	{
+		Name:    "amp_html_suffix",
+		Domain:  anyDomain,
+		Match:   `(?i)^(https?://[^?#]+)\.amp\.html`,
+		Rewrite: "${1}.html",
+	},
+})
+
+// A ruleCache keeps the compiled rewrite rules of each server, and the
+// global ones under an empty server ID, so the rules table isn't queried
+// for every URL.
+type ruleCache struct {
+	mu       sync.Mutex
+	byServer map[string][]RewriteRule
+}
+
+func newRuleCache() *ruleCache {
+	return &ruleCache{byServer: map[string][]RewriteRule{}}
+}
+
+func (c *ruleCache) get(serverID string) ([]RewriteRule, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	rules, ok := c.byServer[serverID]
+	return rules, ok
+}
+
+func (c *ruleCache) set(serverID string, rules []RewriteRule) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.byServer[serverID] = rules
+}
+
+func (c *ruleCache) forget(serverID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.byServer, serverID)
+}
+
+// compileRewriteRules compiles the match pattern of each rule, leaving out
+// rules whose pattern doesn't compile.
+func compileRewriteRules(rules []RewriteRule) []RewriteRule {
+	var compiled []RewriteRule
+	for _, rule := range rules {
+		pattern, err := regexp.Compile(rule.Match)
+		if err != nil {
+			log.WithError(err).WithField("rule", rule.Name).Error("unable to compile rewrite rule")
+			continue
+		}
+		rule.pattern = pattern
+		compiled = append(compiled, rule)
+	}
+	return compiled
+}
+
+// appliesTo reports whether a rule is scoped to the domain of a URL.
+// Subdomains of the rule's domain are included.
+func (r RewriteRule) appliesTo(u *url.URL) bool {
+	if r.Domain == anyDomain || r.Domain == "" {
+		return true
+	}
+	host := strings.ToLower(u.Hostname())
+	domain := strings.ToLower(r.Domain)
+	return host == domain || strings.HasSuffix(host, "."+domain)
+}
+
+// apply rewrites a URL with the rule, returning false if the rule didn't
+// match or didn't produce a different, valid URL on the same site. Rules
+// scoped to a domain also have to stay on it.
+func (r RewriteRule) apply(requestURL string) (string, bool) {
+	original, err := url.Parse(requestURL)
+	if err != nil || r.pattern == nil || !r.pattern.MatchString(requestURL) {
+		return "", false
+	}
+	rewritten := r.pattern.ReplaceAllString(requestURL, r.Rewrite)
+
+	// Removing a query parameter can leave a dangling separator
+	rewritten = strings.TrimRight(rewritten, "?&")
+	parsed, err := url.Parse(rewritten)
+	if err != nil || rewritten == requestURL || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return "", false
+	}
+	if !sameSite(original, parsed) || !r.appliesTo(parsed) {
+		return "", false
+	}
+	return rewritten, true
+}
+
+// sameSite reports whether two URLs are on the same site: one's host is
+// the other's or a subdomain of it, and they have the same domain name.
+// This keeps rules from pointing links somewhere else entirely.
+func sameSite(a, b *url.URL) bool {
+	hostA, hostB := strings.ToLower(a.Hostname()), strings.ToLower(b.Hostname())
+	related := hostA == hostB || strings.HasSuffix(hostA, "."+hostB) || strings.HasSuffix(hostB, "."+hostA)
+	domainA, errA := getDomainName(a.String())
+	domainB, errB := getDomainName(b.String())
+	return related && errA == nil && errB == nil && domainA == domainB
+}
+
+// loadRewriteRules returns the rules added for a server, or the global
+// ones for an empty server ID, from the cache or else the database.
+func (bot *AmputatorBot) loadRewriteRules(ctx context.Context, serverID string) []RewriteRule {
+	if rules, ok := bot.rules.get(serverID); ok {
+		return rules
+	}
+	var rules []RewriteRule
+	bot.DB.WithContext(ctx).Where("server_id = ?", serverID).Order("created_at").Find(&rules)
+	rules = compileRewriteRules(rules)
+	bot.rules.set(serverID, rules)
+	return rules
+}
+
+// rewriteRules returns the rules to try for a server, in order: the
+// server's own, then global ones added by admins, then the built-in ones.
+func (bot *AmputatorBot) rewriteRules(ctx context.Context, serverID string) []RewriteRule {
+	var rules []RewriteRule
+	if serverID != "" {
+		rules = append(rules, bot.loadRewriteRules(ctx, serverID)...)
+	}
+	rules = append(rules, bot.loadRewriteRules(ctx, "")...)
+	return append(rules, builtinRewriteRules...)
+}
+
+// rewriteURL runs the rewrite rules for a server against a URL and returns
+// the first rewritten URL along with the rule that matched.
+func (bot *AmputatorBot) rewriteURL(ctx context.Context, requestURL string, serverID string) (string, RewriteRule, bool) {
+	// AMP caches embed the original URL, which rules don't know about
+	parsed, err := url.Parse(requestURL)
+	if err != nil || isAmpCacheHost(parsed) {
+		return "", RewriteRule{}, false
+	}
+	for _, rule := range bot.rewriteRules(ctx, serverID) {
+		if !rule.appliesTo(parsed) {
+			continue
+		}
+		if rewritten, ok := rule.apply(requestURL); ok {
+			return rewritten, rule, true
+		}
+	}
+	return "", RewriteRule{}, false
+}
+
+// validateRewriteRule returns an error describing why a rule can't be
+// added, or nil if it can.
+func validateRewriteRule(rule RewriteRule) error {
+	if rule.Name == "" || rule.Domain == "" || rule.Match == "" || rule.Rewrite == "" {
+		return fmt.Errorf("rule is missing a name, domain, match or rewrite")
+	}
+	if _, err := regexp.Compile(rule.Match); err != nil {
+		return fmt.Errorf("invalid match pattern: %w", err)
+	}
+	for _, builtin := range builtinRewriteRules {
+		if rule.Name == builtin.Name {
+			return fmt.Errorf("%v is the name of a built-in rule", rule.Name)
+		}
+	}
+	return nil
+}
+
+// handleRuleCommand lists, adds and removes rewrite rules. Server rules
+// can be changed by people who can manage the server, global rules only by
+// bot admins. Syntax:
+// (commandPrefix) rule list
+// (commandPrefix) rule add [global] (name) (domain|*) (match) (rewrite)
+// (commandPrefix) rule remove [global] (name)
+func (bot *AmputatorBot) handleRuleCommand(ctx context.Context, s Session, m *discordgo.Message, logger *log.Entry) error {
+	language := responseLanguage(bot.getMessageConfig(m), "")
+	usageEmbed := &discordgo.MessageEmbed{
+		Title:       translate(language, "Unable to set %v", ruleCommand),
+		Description: translate(language, "See %v for usage", amputatorRepoUrl),
+	}
+
+	words := strings.Fields(m.Content)
+	action := "list"
+	if len(words) > 2 {
+		action = words[2]
+	}
+	args := words[min(len(words), 3):]
+
+	// Global rules are only for bot admins, server rules need a server
+	serverID := m.GuildID
+	if len(args) > 0 && args[0] == "global" {
+		if !bot.isAdmin(m.Author.ID) {
+			return fmt.Errorf("did not change global rules for %v(%v) because user is not an administrator",
+				m.Author.Username, m.Author.ID)
+		}
+		serverID = ""
+		args = args[1:]
+	} else if serverID == "" && action != "list" {
+		bot.sendMessage(ctx, s, true, false, m, usageEmbed, logger)
+		return fmt.Errorf("server rules can only be changed in a server")
+	} else if action != "list" && !bot.managesServer(ctx, s, m) && !bot.isAdmin(m.Author.ID) {
+		return fmt.Errorf("did not change rules for %v(%v) because user cannot manage the server",
+			m.Author.Username, m.Author.ID)
+	}
+
+	switch {
+	case action == "list":
+		var fields []*discordgo.MessageEmbedField
+		for _, rule := range bot.rewriteRules(ctx, m.GuildID) {
+			scope := translate(language, "Built-in")
+			if rule.UUID != "" && rule.ServerID == "" {
+				scope = translate(language, "Global")
+			} else if rule.UUID != "" {
+				scope = translate(language, "Server")
+			}
+			fields = append(fields, &discordgo.MessageEmbedField{
+				Name:  fmt.Sprintf("%v (%v, %v)", rule.Name, scope, rule.Domain),
+				Value: fmt.Sprintf("`%v` → `%v`", rule.Match, rule.Rewrite),
+			})
+		}
+		bot.sendMessage(ctx, s, true, false, m, &discordgo.MessageEmbed{
+			Title:  translate(language, "Rewrite Rules"),
+			Fields: fields[:min(len(fields), 25)],
+		}, logger)
+		return nil
+	case action == "add" && len(args) == 4:
+		rule := RewriteRule{
+			UUID:     uuid.New().String(),
+			ServerID: serverID,
+			Name:     args[0],
+			Domain:   strings.ToLower(args[1]),
+			Match:    args[2],
+			Rewrite:  args[3],
+			AddedBy:  m.Author.ID,
+		}
+		if err := validateRewriteRule(rule); err != nil {
+			bot.sendMessage(ctx, s, true, false, m, usageEmbed, logger)
+			return err
+		}
+
+		var existing int64
+		bot.DB.WithContext(ctx).Model(&RewriteRule{}).Where("server_id = ? AND name = ?", serverID, rule.Name).Count(&existing)
+		if existing > 0 {
+			bot.sendMessage(ctx, s, true, false, m, usageEmbed, logger)
+			return fmt.Errorf("a rule named %v already exists", rule.Name)
+		}
+		if serverID != "" {
+			var count int64
+			bot.DB.WithContext(ctx).Model(&RewriteRule{}).Where(&RewriteRule{ServerID: serverID}).Count(&count)
+			if count >= int64(maxServerRewriteRules) {
+				bot.sendMessage(ctx, s, true, false, m, usageEmbed, logger)
+				return fmt.Errorf("server already has %v rules", count)
+			}
+		}
+
+		if tx := bot.DB.WithContext(ctx).Create(&rule); tx.RowsAffected != 1 {
+			return fmt.Errorf("did not expect %v rows to be affected adding rule %v", tx.RowsAffected, rule.Name)
+		}
+		bot.rules.forget(serverID)
+		logger.WithFields(log.Fields{"rule": rule.Name, "server_id": serverID}).Info("rewrite rule added")
+		bot.sendMessage(ctx, s, true, false, m, &discordgo.MessageEmbed{
+			Title:       translate(language, "Rule Added"),
+			Description: rule.Name,
+		}, logger)
+	case action == "remove" && len(args) == 1:
+		tx := bot.DB.WithContext(ctx).Where("server_id = ? AND name = ?", serverID, args[0]).Delete(&RewriteRule{})
+		if tx.RowsAffected != 1 {
+			bot.sendMessage(ctx, s, true, false, m, usageEmbed, logger)
+			return fmt.Errorf("no rule named %v", args[0])
+		}
+		bot.rules.forget(serverID)
+		logger.WithFields(log.Fields{"rule": args[0], "server_id": serverID}).Info("rewrite rule removed")
+		bot.sendMessage(ctx, s, true, false, m, &discordgo.MessageEmbed{
+			Title:       translate(language, "Rule Removed"),
+			Description: args[0],
+		}, logger)
+	default:
+		bot.sendMessage(ctx, s, true, false, m, usageEmbed, logger)
+	}
+
+	return nil
+}
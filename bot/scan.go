@@ -0,0 +1,358 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/google/uuid"
+	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+)
+
+const (
+	// scanPageSize is the most messages Discord returns per request.
+	scanPageSize int = 100
+
+	// maxScanMessages stops a scan from running forever on huge channels.
+	maxScanMessages int = 10000
+)
+
+var channelMentionRegex = regexp.MustCompile(`^<#(\d+)>$`)
+
+// A scanTracker keeps the cancel functions of running channel scans, by
+// channel ID, so they can be cancelled and only one runs per channel.
+type scanTracker struct {
+	mu      sync.Mutex
+	cancels map[string]context.CancelFunc
+}
+
+// start records a scan of a channel, returning false if one is already
+// running.
+func (t *scanTracker) start(channelID string, cancel context.CancelFunc) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if _, ok := t.cancels[channelID]; ok {
+		return false
+	}
+	t.cancels[channelID] = cancel
+	return true
+}
+
+// finish forgets about a scan once it's done.
+func (t *scanTracker) finish(channelID string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.cancels, channelID)
+}
+
+// cancel cancels the scan of a channel, returning false if there isn't one.
+func (t *scanTracker) cancel(channelID string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	cancel, ok := t.cancels[channelID]
+	if ok {
+		cancel()
+	}
+	return ok
+}
+
+// running reports whether a channel is being scanned.
+func (t *scanTracker) running(channelID string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	_, ok := t.cancels[channelID]
+	return ok
+}
+
+func newScanTracker() *scanTracker {
+	return &scanTracker{cancels: map[string]context.CancelFunc{}}
+}
+
+// A channelScan is a backfill of the AMP links already posted in a channel.
+type channelScan struct {
+	ChannelID string
+	GuildID   string
+	Since     time.Time
+	Reply     bool
+	Progress  *discordgo.Message
+	Language  string
+
+	Checked   int
+	Found     int
+	Amputated int64
+}
+
+// summary describes how far along a scan is.
+func (scan channelScan) summary(status string) string {
+	return translate(scan.Language, status, "<#"+scan.ChannelID+">") + "\n" +
+		translate(scan.Language, "%v messages checked, %v AMP links found, %v amputated",
+			scan.Checked, scan.Found, scan.Amputated)
+}
+
+// parseSince parses how far back to scan: a number of days like 30d, a
+// duration like 12h or a date like 2024-01-31.
+func parseSince(value string) (time.Time, error) {
+	if days, ok := strings.CutSuffix(value, "d"); ok {
+		if n, err := strconv.Atoi(days); err == nil && n > 0 {
+			return time.Now().AddDate(0, 0, -n), nil
+		}
+	}
+	if d, err := time.ParseDuration(value); err == nil && d > 0 {
+		return time.Now().Add(-d), nil
+	}
+	return time.Parse("2006-01-02", value)
+}
+
+// handleScanCommand starts or cancels a backfill of a channel's history.
+// Only bot admins can use it. Syntax:
+// (commandPrefix) scan (#channel) [since] [reply]
+// (commandPrefix) scan cancel (#channel)
+func (bot *AmputatorBot) handleScanCommand(ctx context.Context, s Session, m *discordgo.Message, logger *log.Entry) error {
+	if !bot.isAdmin(m.Author.ID) {
+		return fmt.Errorf("did not respond to %v(%v), command %v because user is not an administrator",
+			m.Author.Username, m.Author.ID, scanCommand)
+	}
+	sc := bot.getMessageConfig(m)
+	language := responseLanguage(sc, "")
+	usageEmbed := &discordgo.MessageEmbed{
+		Title:       translate(language, "Unable to set %v", scanCommand),
+		Description: translate(language, "See %v for usage", amputatorRepoUrl),
+	}
+
+	words := strings.Fields(m.Content)[2:]
+	cancelling := len(words) > 0 && words[0] == "cancel"
+	if cancelling {
+		words = words[1:]
+	}
+	if len(words) == 0 || m.GuildID == "" {
+		bot.sendMessage(ctx, s, true, false, m, usageEmbed, logger)
+		return fmt.Errorf("scan needs a channel and can only be used in a server")
+	}
+	mention := channelMentionRegex.FindStringSubmatch(words[0])
+	if mention == nil {
+		bot.sendMessage(ctx, s, true, false, m, usageEmbed, logger)
+		return fmt.Errorf("not a channel: %v", words[0])
+	}
+
+	// Only channels in the server the command was used in can be scanned
+	if err := serverChannel(s, m.GuildID, mention[1]); err != nil {
+		bot.sendMessage(ctx, s, true, false, m, usageEmbed, logger)
+		return fmt.Errorf("unable to scan %v: %w", words[0], err)
+	}
+	scan := channelScan{ChannelID: mention[1], GuildID: m.GuildID, Language: language}
+	logger = logger.WithField("scan_channel", scan.ChannelID)
+
+	if cancelling {
+		status := "Cancelling the scan of %v"
+		if !bot.scans.cancel(scan.ChannelID) {
+			status = "No scan is running in %v"
+		}
+		bot.sendMessage(ctx, s, true, false, m, &discordgo.MessageEmbed{
+			Title:       translate(language, "Channel Scan"),
+			Description: translate(language, status, words[0]),
+		}, logger)
+		return nil
+	}
+
+	for _, option := range words[1:] {
+		if option == "reply" {
+			scan.Reply = true
+			continue
+		}
+		since, err := parseSince(option)
+		if err != nil {
+			bot.sendMessage(ctx, s, true, false, m, usageEmbed, logger)
+			return fmt.Errorf("unable to parse since %v: %w", option, err)
+		}
+		scan.Since = since
+	}
+
+	// The scan outlives the command, so it isn't tied to its context
+	scanCtx, cancel := context.WithCancel(context.Background())
+	if !bot.scans.start(scan.ChannelID, cancel) {
+		cancel()
+		bot.sendMessage(ctx, s, true, false, m, &discordgo.MessageEmbed{
+			Title:       translate(language, "Channel Scan"),
+			Description: translate(language, "A scan is already running in %v", words[0]),
+		}, logger)
+		return nil
+	}
+
+	scan.Progress = bot.sendMessageWithComponents(ctx, s, true, false, m, &discordgo.MessageEmbed{
+		Title:       translate(language, "Channel Scan"),
+		Description: scan.summary("Scanning %v"),
+	}, nil, 0, logger)
+	if scan.Progress == nil {
+		bot.scans.finish(scan.ChannelID)
+		cancel()
+		return fmt.Errorf("unable to send scan progress message")
+	}
+
+	logger.WithFields(log.Fields{"since": scan.Since, "reply": scan.Reply}).Info("starting channel scan")
+	go func() {
+		defer bot.scans.finish(scan.ChannelID)
+		defer cancel()
+		bot.scanChannel(scanCtx, s, sc, scan, logger)
+	}()
+	return nil
+}
+
+// scanChannel pages back through a channel's history, newest first, and
+// amputates the AMP links it finds into the cache. Discord's rate limits
+// are handled by the session, and there's a pause of scanDelay between
+// requests on top of that.
+func (bot *AmputatorBot) scanChannel(ctx context.Context, s Session, sc ServerConfig, scan channelScan, logger *log.Entry) {
+	ctx, span := tracer.Start(ctx, "scanChannel")
+	defer span.End()
+
+	var err error
+	status := "Finished scanning %v"
+	before := ""
+pages:
+	for scan.Checked < maxScanMessages {
+		var messages []*discordgo.Message
+		messages, err = s.ChannelMessages(scan.ChannelID, scanPageSize, before, "", "", discordgo.WithContext(ctx))
+		if err != nil || len(messages) == 0 {
+			break
+		}
+		before = messages[len(messages)-1].ID
+
+		for _, message := range messages {
+			if message.Timestamp.Before(scan.Since) {
+				break pages
+			}
+			scan.Checked++
+			if err = bot.scanMessage(ctx, s, sc, &scan, message, logger); err != nil {
+				break pages
+			}
+		}
+
+		bot.editScanProgress(ctx, s, scan, scan.summary("Scanning %v"), logger)
+		if err = sleepContext(ctx, scanDelay); err != nil {
+			break
+		}
+	}
+
+	switch {
+	case errors.Is(err, context.Canceled):
+		status = "Cancelled scanning %v"
+	case err != nil:
+		span.SetStatus(codes.Error, err.Error())
+		logger.WithError(err).Error("channel scan stopped")
+		status = "Stopped scanning %v after an error"
+	}
+	span.SetAttributes(
+		attribute.Int("scan.checked", scan.Checked),
+		attribute.Int("scan.found", scan.Found),
+	)
+
+	// The progress message is edited even if the scan was cancelled
+	bot.editScanProgress(context.Background(), s, scan, scan.summary(status), logger)
+	logger.WithFields(log.Fields{
+		"checked":   scan.Checked,
+		"found":     scan.Found,
+		"amputated": scan.Amputated,
+	}).Info("channel scan done")
+}
+
+// scanMessage amputates the AMP links in one message from a scan, either
+// quietly into the cache or by replying to it.
+func (bot *AmputatorBot) scanMessage(ctx context.Context, s Session, sc ServerConfig, scan *channelScan,
+	message *discordgo.Message, logger *log.Entry) error {
+	links := bot.messageAmpLinks(s, sc, message)
+	if len(links) == 0 {
+		return nil
+	}
+	scan.Found += len(links)
+
+	// Messages from history don't say which guild they're from
+	message.GuildID = scan.GuildID
+	logger = messageLogger(message).WithField("scan_channel", scan.ChannelID)
+
+	if scan.Reply {
+		if err := bot.handleMessageWithAmpUrls(ctx, s, &discordgo.MessageCreate{Message: message}, links, logger); err != nil {
+			logger.WithError(err).Warn("unable to handle message with AMP urls during scan")
+		}
+		if err := sleepContext(ctx, scanDelay); err != nil {
+			return err
+		}
+	} else {
+		bot.recordAmputations(ctx, sc, message, links, logger)
+	}
+
+	var amputated int64
+	bot.DB.WithContext(ctx).Model(&Amputation{}).
+		Joins("JOIN amputation_events ON amputation_events.uuid = amputations.amputation_event_uuid").
+		Where("amputation_events.message_id = ? AND amputations.response_url <> ?", message.ID, "").
+		Count(&amputated)
+	scan.Amputated += amputated
+	return ctx.Err()
+}
+
+// messageAmpLinks returns the AMP links in a message from a channel's
+// history, skipping the bot's own messages and reposts and, if the server
+// doesn't want them, messages from other bots and webhooks.
+func (bot *AmputatorBot) messageAmpLinks(s Session, sc ServerConfig, message *discordgo.Message) []messageLink {
+	if message.Author == nil || (s.BotUser() != nil && message.Author.ID == s.BotUser().ID) || bot.isOwnRepost(message) {
+		return nil
+	}
+	if (message.Author.Bot || message.WebhookID != "") && !sc.ProcessBots {
+		return nil
+	}
+	return filterAmpLinks(messageLinks(message), sc.Sensitivity)
+}
+
+// recordAmputations amputates the links in a message without replying to
+// it and records them as an amputation event. Messages that were already
+// handled are skipped.
+func (bot *AmputatorBot) recordAmputations(ctx context.Context, sc ServerConfig, message *discordgo.Message,
+	links []messageLink, logger *log.Entry) []Amputation {
+	if !bot.recentLinks.claim(message.ID, time.Now()) {
+		logger.Debug("ignoring message that was already handled")
+		return nil
+	}
+	ampEventUUID := uuid.New().String()
+	amputations, _ := bot.amputateLinks(ctx, links, sc, message.GuildID, ampEventUUID, logger)
+	bot.DB.WithContext(ctx).Create(&AmputationEvent{
+		UUID:           ampEventUUID,
+		AuthorId:       message.Author.ID,
+		AuthorUsername: message.Author.Username,
+		ChannelId:      message.ChannelID,
+		MessageId:      message.ID,
+		ServerID:       message.GuildID,
+		Amputations:    amputations,
+	})
+	return amputations
+}
+
+// editScanProgress updates the scan's progress message.
+func (bot *AmputatorBot) editScanProgress(ctx context.Context, s Session, scan channelScan, description string, logger *log.Entry) {
+	embeds := []*discordgo.MessageEmbed{{
+		Title:       translate(scan.Language, "Channel Scan"),
+		Description: description,
+	}}
+	edit := discordgo.NewMessageEdit(scan.Progress.ChannelID, scan.Progress.ID)
+	edit.Embeds = &embeds
+	if _, err := s.ChannelMessageEditComplex(edit, discordgo.WithContext(ctx)); err != nil {
+		logger.WithError(err).Warn("unable to update scan progress")
+	}
+}
+
+// sleepContext sleeps for d, returning early with the context's error if
+// it's cancelled.
+func sleepContext(ctx context.Context, d time.Duration) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(d):
+		return nil
+	}
+}
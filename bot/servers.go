@@ -1,14 +1,14 @@
 package bot
 
 import (
+	"context"
 	"fmt"
-	"strconv"
+	"slices"
 	"strings"
 	"time"
 
 	"github.com/bwmarrin/discordgo"
 	log "github.com/sirupsen/logrus"
-	"gorm.io/gorm"
 )
 
 type ServerRegistration struct {
@@ -26,6 +26,27 @@ type ServerConfig struct {
 	UseEmbed               bool   `pretty:"Use embed to reply"`
 	GuessAndCheck          bool   `pretty:"Guess at AMP URLs if they are difficult"`
 	MaxDepth               int    `pretty:"How many links deep to go to try to find the non-AMP link"`
+	ReplyLayout            string `gorm:"default:compact" pretty:"Reply layout (compact or rich)"`
+	Language               string `gorm:"default:en" pretty:"Language"`
+	ReplyTemplate          string `pretty:"Reply template"`
+	Sensitivity            string `gorm:"default:medium" pretty:"AMP link detection sensitivity (low, medium or high)"`
+	ProcessBots            bool   `gorm:"default:true" pretty:"Amputate links from other bots and webhooks"`
+	SuppressOriginalEmbeds bool   `pretty:"Hide previews on messages with AMP links"`
+	ReplyPreviews          bool   `gorm:"default:true" pretty:"Show previews in plain text replies"`
+	ReplyMode              string `gorm:"default:reply" pretty:"Reply mode (reply or replace)"`
+	CatchUp                string `gorm:"default:digest" pretty:"Catch up on missed messages (off, reply or digest)"`
+	LogChannel             string `pretty:"Log channel"`
+	LogConfigChanges       bool   `gorm:"default:true" pretty:"Log config changes"`
+	LogFailures            bool   `gorm:"default:true" pretty:"Log amputation failures"`
+	LogPermissions         bool   `gorm:"default:true" pretty:"Log permission problems"`
+	LogRateLimits          bool   `gorm:"default:true" pretty:"Log rate limits"`
+	CommandPrefix          string `gorm:"default:!amp" pretty:"Command prefix"`
+	RateLimitAction        string `gorm:"default:react" pretty:"What to do with messages over a rate limit (drop, batch or react)"`
+	UserRateLimit          int    `gorm:"default:10" pretty:"Messages handled per user per minute (0 for no limit)"`
+	ChannelRateLimit       int    `gorm:"default:30" pretty:"Messages handled per channel per minute (0 for no limit)"`
+	ServerRateLimit        int    `gorm:"default:100" pretty:"Messages handled per server per minute (0 for no limit)"`
+	DuplicateAction        string `gorm:"default:link" pretty:"What to do with links amputated recently in the channel (reply, react or link)"`
+	DuplicateWindow        int    `gorm:"default:10" pretty:"Minutes links are remembered for in each channel"`
 }
 
 var (
@@ -37,6 +58,47 @@ var (
 		UseEmbed:               true,
 		GuessAndCheck:          true,
 		MaxDepth:               3,
+		ReplyLayout:            compactLayout,
+		Language:               defaultLanguage,
+		Sensitivity:            defaultSensitivity,
+		ProcessBots:            true,
+		ReplyPreviews:          true,
+		ReplyMode:              replyMode,
+		CatchUp:                catchUpDigest,
+		LogConfigChanges:       true,
+		LogFailures:            true,
+		LogPermissions:         true,
+		LogRateLimits:          true,
+		CommandPrefix:          commandPrefix,
+		RateLimitAction:        rateLimitReact,
+		UserRateLimit:          defaultUserRateLimit,
+		ChannelRateLimit:       defaultChannelRateLimit,
+		ServerRateLimit:        defaultServerRateLimit,
+		DuplicateAction:        duplicateLink,
+		DuplicateWindow:        defaultDuplicateWindow,
+	}
+
+	// directMessageConfig is used for links sent to the bot directly. It
+	// isn't stored since DMs aren't tied to a server.
+	directMessageConfig ServerConfig = ServerConfig{
+		Name:              "direct message",
+		AmputationEnabled: true,
+		UseEmbed:          true,
+		GuessAndCheck:     true,
+		MaxDepth:          3,
+		ReplyLayout:       compactLayout,
+		Language:          defaultLanguage,
+		Sensitivity:       defaultSensitivity,
+		ProcessBots:       true,
+		ReplyPreviews:     true,
+		ReplyMode:         replyMode,
+		CatchUp:           catchUpOff,
+		CommandPrefix:     commandPrefix,
+		RateLimitAction:   rateLimitReact,
+		UserRateLimit:     defaultUserRateLimit,
+		ChannelRateLimit:  defaultChannelRateLimit,
+		DuplicateAction:   duplicateLink,
+		DuplicateWindow:   defaultDuplicateWindow,
 	}
 
 	amputatorRepoUrl string = "https://github.com/tyzbit/go-discord-amputator"
@@ -44,9 +106,9 @@ var (
 
 // registerOrUpdateGuild checks if a guild is already registered in the database. If not,
 // it creates it with sensibile defaults.
-func (bot *AmputatorBot) registerOrUpdateGuild(s *discordgo.Session, g *discordgo.Guild) error {
+func (bot *AmputatorBot) registerOrUpdateGuild(s Session, g *discordgo.Guild) error {
 	var registration ServerRegistration
-	bot.DB.Find(&registration, g.ID)
+	bot.DB.Where(&ServerRegistration{DiscordId: g.ID}).Find(&registration)
 
 	// Do a lookup for the full guild object
 	guild, err := s.Guild(g.ID)
@@ -56,12 +118,16 @@ func (bot *AmputatorBot) registerOrUpdateGuild(s *discordgo.Session, g *discordg
 
 	// The server registration does not exist, so we will create with defaults
 	if (registration == ServerRegistration{}) {
-		log.Info("creating registration for new server: ", guild.Name, "(", g.ID, ")")
+		guildLogger(guild).Info("creating registration for new server")
+
+		// Respond in the guild's preferred language to start with
+		config := defaultServerConfig
+		config.Language = languageForLocale(discordgo.Locale(guild.PreferredLocale))
 		tx := bot.DB.Create(&ServerRegistration{
 			DiscordId: g.ID,
 			Name:      guild.Name,
 			UpdatedAt: time.Now(),
-			Config:    defaultServerConfig,
+			Config:    config,
 		})
 
 		// We only expect one server to be updated at a time. Otherwise, return an error.
@@ -90,9 +156,23 @@ func (bot *AmputatorBot) getServerConfig(guildId string) ServerConfig {
 	return sc
 }
 
-// setServerConfig sets a single config setting for the calling server. Syntax:
+// getMessageConfig returns the config that applies to a message: the
+// server's config, or directMessageConfig if it was sent as a DM.
+func (bot *AmputatorBot) getMessageConfig(m *discordgo.Message) ServerConfig {
+	if m.GuildID == "" {
+		return directMessageConfig
+	}
+	return bot.getServerConfig(m.GuildID)
+}
+
+// setServerConfig changes the calling server's config. Syntax:
+// (commandPrefix) config [get]
 // (commandPrefix) config [setting] [value]
-func (bot *AmputatorBot) setServerConfig(s *discordgo.Session, m *discordgo.Message) error {
+// (commandPrefix) config reset [setting]
+// (commandPrefix) config help [setting]
+// (commandPrefix) config history
+// (commandPrefix) config revert [change]
+func (bot *AmputatorBot) setServerConfig(ctx context.Context, s Session, m *discordgo.Message, logger *log.Entry) error {
 	// Look up the guild from the message
 	guild, err := s.Guild(m.GuildID)
 	if err != nil {
@@ -106,68 +186,97 @@ func (bot *AmputatorBot) setServerConfig(s *discordgo.Session, m *discordgo.Mess
 		if err != nil {
 			return fmt.Errorf("unable to register guild: %w", err)
 		}
+		sc = bot.getServerConfig(m.GuildID)
+	}
+
+	command := strings.Fields(m.Content)
+	subcommand, argument := "get", ""
+	if len(command) > 2 {
+		subcommand = strings.ToLower(command[2])
+	}
+	if len(command) > 3 {
+		argument = command[3]
 	}
 
-	command := strings.Split(m.Content, " ")
-	var setting, value string
-	if len(command) == 4 {
-		setting = command[2]
-		value = command[3]
-	} else {
-		setting = "get"
+	language := responseLanguage(sc, "")
+	sendError := func(err error) error {
+		bot.sendMessage(ctx, s, sc.UseEmbed, sc.ReplyToOriginalMessage, m, &discordgo.MessageEmbed{
+			Title:       translate(language, "Unable to change config"),
+			Description: translateError(language, err) + "\n" + translate(language, "See %v for usage", amputatorRepoUrl),
+		}, logger)
+		return err
 	}
 
-	errorEmbed := &discordgo.MessageEmbed{
-		Title:       "Unable to set " + value,
-		Description: "See " + amputatorRepoUrl + " for usage",
+	// Changes are limited to people who can manage the server, like the
+	// slash command is by default.
+	if !slices.Contains([]string{"get", "help", "history"}, subcommand) && !bot.managesServer(ctx, s, m) {
+		return sendError(errNotServerManager)
 	}
 
-	tx := &gorm.DB{}
-	switch setting {
-	// "get" is the only command that does not alter the database.
+	switch subcommand {
+	// "get", "help" and "history" are the only commands that do not alter the database.
 	case "get":
-		bot.sendMessage(s, true, false, m, &discordgo.MessageEmbed{
-			Title:  "Amputator Config",
-			Fields: structToPrettyDiscordFields(sc),
-		})
+		bot.sendEmbeds(ctx, s, m, settingsEmbeds(sc, language), nil, logger)
+		return nil
+	case "help":
+		setting, ok := lookUpSetting(argument)
+		if !ok {
+			return sendError(newTranslatableError("unknown setting %v, expected one of %v", argument, settingNames()))
+		}
+		bot.sendMessage(ctx, s, true, false, m, settingHelpEmbed(setting, sc, language), logger)
 		return nil
-	case "switch":
-		tx = bot.DB.Model(&ServerConfig{}).Where(&ServerConfig{DiscordId: guild.ID}).Update("amputation_enabled", value == "on")
-	case "replyto":
-		tx = bot.DB.Model(&ServerConfig{}).Where(&ServerConfig{DiscordId: guild.ID}).Update("reply_to_original_message", value == "on")
-	case "embed":
-		tx = bot.DB.Model(&ServerConfig{}).Where(&ServerConfig{DiscordId: guild.ID}).Update("use_embed", value == "on")
-	case "guess":
-		tx = bot.DB.Model(&ServerConfig{}).Where(&ServerConfig{DiscordId: guild.ID}).Update("guess_and_check", value == "on")
-	case "maxdepth":
-		maxDepth, err := strconv.Atoi(value)
+	case "history":
+		bot.sendMessage(ctx, s, true, false, m, bot.configHistoryEmbed(ctx, guild.ID, language), logger)
+		return nil
+	case "revert":
+		change, err := bot.revertConfigChange(ctx, s, guild.ID, argument, m.Author, configSourceText, logger)
 		if err != nil {
-			bot.sendMessage(s, sc.UseEmbed, sc.ReplyToOriginalMessage, m, errorEmbed)
-			return fmt.Errorf("unable to convert max depth from string to integer")
+			return sendError(err)
 		}
-		tx = bot.DB.Model(&ServerConfig{}).Where(&ServerConfig{DiscordId: guild.ID}).Update("max_depth", maxDepth)
-	default:
-		bot.sendMessage(s, sc.UseEmbed, sc.ReplyToOriginalMessage, m, errorEmbed)
+		bot.sendMessage(ctx, s, sc.UseEmbed, sc.ReplyToOriginalMessage, m, &discordgo.MessageEmbed{
+			Title: translate(language, "Setting Updated"),
+			Description: translate(language, "%v set to %v",
+				translate(language, getTagValue(sc, change.Setting, "pretty")), change.OldValue),
+		}, logger)
+		return nil
+	case "reset":
+		if err := bot.resetSettings(ctx, s, guild.ID, argument, m.Author, configSourceText, logger); err != nil {
+			return sendError(err)
+		}
+		language = responseLanguage(bot.getServerConfig(guild.ID), "")
+		description := translate(language, "Every setting was reset to its default")
+		if argument != "" {
+			description = translate(language, "%v reset to its default", strings.ToLower(argument))
+		}
+		bot.sendMessage(ctx, s, sc.UseEmbed, sc.ReplyToOriginalMessage, m, &discordgo.MessageEmbed{
+			Title:       translate(language, "Setting Updated"),
+			Description: description,
+		}, logger)
 		return nil
 	}
 
-	// We only expect one server to be updated at a time. Otherwise, return an error.
-	if tx.RowsAffected != 1 {
-		return fmt.Errorf("did not expect %v rows to be affected updating "+
-			"server config for server: %v(%v)", fmt.Sprintf("%v", tx.RowsAffected), guild.Name, guild.ID)
+	if len(command) != 4 {
+		return sendError(newTranslatableError("expected a setting and a value"))
+	}
+	setting, err := bot.setSetting(ctx, s, guild.ID, subcommand, argument, m.Author, configSourceText, logger)
+	if err != nil {
+		return sendError(err)
 	}
 
-	bot.sendMessage(s, sc.UseEmbed, sc.ReplyToOriginalMessage, m, &discordgo.MessageEmbed{
-		Title:       "Setting Updated",
-		Description: setting + " set to " + value,
-	})
+	// Confirm in the new language if that's what changed
+	updated := bot.getServerConfig(guild.ID)
+	language = responseLanguage(updated, "")
+	bot.sendMessage(ctx, s, sc.UseEmbed, sc.ReplyToOriginalMessage, m, &discordgo.MessageEmbed{
+		Title:       translate(language, "Setting Updated"),
+		Description: translate(language, "%v set to %v", setting.Name, setting.value(updated)),
+	}, logger)
 
 	return nil
 }
 
 // updateServersWatched updates the servers watched value
 // in both the local bot stats and in the database. It is allowed to fail.
-func (bot *AmputatorBot) updateServersWatched(s *discordgo.Session) error {
+func (bot *AmputatorBot) updateServersWatched(s Session) error {
 	var serversWatched int64
 	bot.DB.Model(&ServerRegistration{}).Where(&ServerRegistration{}).Count(&serversWatched)
 
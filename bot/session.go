@@ -0,0 +1,54 @@
+package bot
+
+import (
+	"github.com/bwmarrin/discordgo"
+)
+
+// Session is the subset of the Discord session the bot uses. Handlers take
+// a Session instead of a *discordgo.Session so they can be exercised
+// without a connection to Discord.
+type Session interface {
+	// BotUser returns the user the bot is logged in as, or nil if it
+	// isn't known yet.
+	BotUser() *discordgo.User
+
+	Guild(guildID string, options ...discordgo.RequestOption) (*discordgo.Guild, error)
+	Channel(channelID string, options ...discordgo.RequestOption) (*discordgo.Channel, error)
+	ChannelTyping(channelID string, options ...discordgo.RequestOption) error
+	ChannelMessages(channelID string, limit int, beforeID, afterID, aroundID string, options ...discordgo.RequestOption) ([]*discordgo.Message, error)
+	ChannelMessageSendComplex(channelID string, data *discordgo.MessageSend, options ...discordgo.RequestOption) (*discordgo.Message, error)
+	ChannelMessageEditComplex(m *discordgo.MessageEdit, options ...discordgo.RequestOption) (*discordgo.Message, error)
+	ChannelMessageDelete(channelID, messageID string, options ...discordgo.RequestOption) error
+	InteractionRespond(interaction *discordgo.Interaction, resp *discordgo.InteractionResponse, options ...discordgo.RequestOption) error
+	UserChannelPermissions(userID, channelID string, options ...discordgo.RequestOption) (int64, error)
+	ChannelWebhooks(channelID string, options ...discordgo.RequestOption) ([]*discordgo.Webhook, error)
+	WebhookCreate(channelID, name, avatar string, options ...discordgo.RequestOption) (*discordgo.Webhook, error)
+	WebhookExecute(webhookID, token string, wait bool, data *discordgo.WebhookParams, options ...discordgo.RequestOption) (*discordgo.Message, error)
+	ApplicationCommandBulkOverwrite(appID string, guildID string, commands []*discordgo.ApplicationCommand, options ...discordgo.RequestOption) ([]*discordgo.ApplicationCommand, error)
+	MessageReactionAdd(channelID, messageID, emojiID string, options ...discordgo.RequestOption) error
+	UpdateStatusComplex(usd discordgo.UpdateStatusData) error
+}
+
+// DiscordSession adapts a *discordgo.Session to the Session interface.
+type DiscordSession struct {
+	*discordgo.Session
+}
+
+// BotUser returns the user from the session state.
+func (s DiscordSession) BotUser() *discordgo.User {
+	if s.State == nil {
+		return nil
+	}
+	return s.State.User
+}
+
+// Channel returns a channel from the session state, or from Discord if it
+// isn't there.
+func (s DiscordSession) Channel(channelID string, options ...discordgo.RequestOption) (*discordgo.Channel, error) {
+	if s.State != nil {
+		if channel, err := s.State.Channel(channelID); err == nil {
+			return channel, nil
+		}
+	}
+	return s.Session.Channel(channelID, options...)
+}
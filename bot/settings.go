@@ -0,0 +1,303 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/bwmarrin/discordgo"
+	log "github.com/sirupsen/logrus"
+)
+
+// Kinds of config settings, which decide how values are parsed.
+const (
+	boolSetting    string = "bool"
+	intSetting     string = "int"
+	choiceSetting  string = "choice"
+	channelSetting string = "channel"
+	textSetting    string = "text"
+)
+
+// A configSetting is a ServerConfig field that can be changed by commands
+// and the admin API. Its description is the field's pretty tag and its
+// default is the field's value in defaultServerConfig. Min and Max are the
+// range of numbers, or the length of text.
+type configSetting struct {
+	Name    string
+	Field   string
+	Kind    string
+	Choices []string
+	Min     int
+	Max     int
+}
+
+// configSettings are every setting servers can change, in the order
+// they're shown. The reply template has its own command since it can
+// contain spaces.
+var configSettings = []configSetting{
+	{Name: "switch", Field: "AmputationEnabled", Kind: boolSetting},
+	{Name: "replyto", Field: "ReplyToOriginalMessage", Kind: boolSetting},
+	{Name: "embed", Field: "UseEmbed", Kind: boolSetting},
+	{Name: "guess", Field: "GuessAndCheck", Kind: boolSetting},
+	{Name: "maxdepth", Field: "MaxDepth", Kind: intSetting, Min: 1, Max: 10},
+	{Name: "layout", Field: "ReplyLayout", Kind: choiceSetting, Choices: []string{compactLayout, richLayout}},
+	{Name: "language", Field: "Language", Kind: choiceSetting, Choices: supportedLanguages()},
+	{Name: "sensitivity", Field: "Sensitivity", Kind: choiceSetting,
+		Choices: []string{lowSensitivity, mediumSensitivity, highSensitivity}},
+	{Name: "bots", Field: "ProcessBots", Kind: boolSetting},
+	{Name: "mode", Field: "ReplyMode", Kind: choiceSetting, Choices: []string{replyMode, replaceMode}},
+	{Name: "suppress", Field: "SuppressOriginalEmbeds", Kind: boolSetting},
+	{Name: "previews", Field: "ReplyPreviews", Kind: boolSetting},
+	{Name: "catchup", Field: "CatchUp", Kind: choiceSetting, Choices: []string{catchUpOff, catchUpReply, catchUpDigest}},
+	{Name: "logchannel", Field: "LogChannel", Kind: channelSetting},
+	{Name: "logconfig", Field: "LogConfigChanges", Kind: boolSetting},
+	{Name: "logfailures", Field: "LogFailures", Kind: boolSetting},
+	{Name: "logpermissions", Field: "LogPermissions", Kind: boolSetting},
+	{Name: "logratelimits", Field: "LogRateLimits", Kind: boolSetting},
+	{Name: "prefix", Field: "CommandPrefix", Kind: textSetting, Min: 1, Max: 10},
+	{Name: "ratelimit", Field: "RateLimitAction", Kind: choiceSetting,
+		Choices: []string{rateLimitDrop, rateLimitBatch, rateLimitReact}},
+	{Name: "userlimit", Field: "UserRateLimit", Kind: intSetting, Min: 0, Max: 120},
+	{Name: "channellimit", Field: "ChannelRateLimit", Kind: intSetting, Min: 0, Max: 600},
+	{Name: "serverlimit", Field: "ServerRateLimit", Kind: intSetting, Min: 0, Max: 6000},
+	{Name: "duplicates", Field: "DuplicateAction", Kind: choiceSetting,
+		Choices: []string{duplicateReply, duplicateReact, duplicateLink}},
+	{Name: "duplicatewindow", Field: "DuplicateWindow", Kind: intSetting, Min: 1, Max: 1440},
+}
+
+// boolValues are the accepted ways of writing a boolean setting.
+var boolValues = map[string]bool{
+	"on": true, "true": true, "yes": true,
+	"off": false, "false": false, "no": false,
+}
+
+// supportedLanguages returns the languages replies can be sent in.
+func supportedLanguages() []string {
+	languages := []string{defaultLanguage}
+	for language := range catalogs {
+		languages = append(languages, language)
+	}
+	sort.Strings(languages[1:])
+	return languages
+}
+
+// lookUpSetting returns the setting with a name.
+func lookUpSetting(name string) (configSetting, bool) {
+	for _, setting := range configSettings {
+		if setting.Name == strings.ToLower(name) {
+			return setting, true
+		}
+	}
+	return configSetting{}, false
+}
+
+// settingNames lists the names of every setting.
+func settingNames() string {
+	var names []string
+	for _, setting := range configSettings {
+		names = append(names, setting.Name)
+	}
+	return strings.Join(names, ", ")
+}
+
+// description returns what the setting does.
+func (cs configSetting) description(language string) string {
+	return translate(language, getTagValue(ServerConfig{}, cs.Field, "pretty"))
+}
+
+// parse parses a value for the setting, returning an error if it isn't
+// allowed.
+func (cs configSetting) parse(value string) (any, error) {
+	switch cs.Kind {
+	case boolSetting:
+		parsed, ok := boolValues[strings.ToLower(value)]
+		if !ok {
+			return nil, newTranslatableError("%v must be on or off, not %v", cs.Name, value)
+		}
+		return parsed, nil
+	case intSetting:
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < cs.Min || parsed > cs.Max {
+			return nil, newTranslatableError("%v must be a number from %v to %v, not %v", cs.Name, cs.Min, cs.Max, value)
+		}
+		return parsed, nil
+	case choiceSetting:
+		for _, choice := range cs.Choices {
+			if strings.EqualFold(value, choice) {
+				return choice, nil
+			}
+		}
+		return nil, newTranslatableError("%v must be one of %v, not %v", cs.Name, strings.Join(cs.Choices, ", "), value)
+	case channelSetting:
+		if strings.EqualFold(value, "off") {
+			return "", nil
+		}
+		if mention := channelMentionRegex.FindStringSubmatch(value); mention != nil {
+			return mention[1], nil
+		}
+		if _, err := strconv.ParseUint(value, 10, 64); err == nil {
+			return value, nil
+		}
+		return nil, newTranslatableError("%v must be a channel or off, not %v", cs.Name, value)
+	case textSetting:
+		length := len([]rune(value))
+		if length < cs.Min || length > cs.Max || strings.ContainsFunc(value, unicode.IsSpace) {
+			return nil, newTranslatableError("%v must be %v to %v characters without spaces, not %v", cs.Name, cs.Min, cs.Max, value)
+		}
+		return value, nil
+	}
+	return nil, fmt.Errorf("unknown kind of setting %v", cs.Kind)
+}
+
+// allowedValues describes the values the setting accepts.
+func (cs configSetting) allowedValues(language string) string {
+	switch cs.Kind {
+	case boolSetting:
+		return "on, off"
+	case intSetting:
+		return translate(language, "a number from %v to %v", cs.Min, cs.Max)
+	case channelSetting:
+		return translate(language, "a channel like #bot-log, or off")
+	case textSetting:
+		return translate(language, "%v to %v characters without spaces", cs.Min, cs.Max)
+	}
+	return strings.Join(cs.Choices, ", ")
+}
+
+// value returns the setting's value in a config, written the way it's
+// set.
+func (cs configSetting) value(sc ServerConfig) string {
+	field := reflect.ValueOf(sc).FieldByName(cs.Field)
+	switch cs.Kind {
+	case boolSetting:
+		if field.Bool() {
+			return "on"
+		}
+		return "off"
+	case channelSetting:
+		if field.String() == "" {
+			return "off"
+		}
+		return "<#" + field.String() + ">"
+	}
+	return fmt.Sprintf("%v", field.Interface())
+}
+
+// defaultValue returns the setting's default value, parsed.
+func (cs configSetting) defaultValue() any {
+	return reflect.ValueOf(defaultServerConfig).FieldByName(cs.Field).Interface()
+}
+
+// updateSettings changes settings on a server, given as values by
+// ServerConfig field, and records the change.
+func (bot *AmputatorBot) updateSettings(ctx context.Context, s Session, guildID string, values map[string]any,
+	user *discordgo.User, source string, logger *log.Entry) error {
+	// Channels have to be in the server, so nothing is posted to another
+	for _, setting := range configSettings {
+		if channelID, ok := values[setting.Field].(string); ok && setting.Kind == channelSetting && channelID != "" {
+			if err := serverChannel(s, guildID, channelID); err != nil {
+				return fmt.Errorf("%v: %w", setting.Name, err)
+			}
+		}
+	}
+
+	before := bot.getServerConfig(guildID)
+	tx := bot.DB.WithContext(ctx).Model(&ServerConfig{}).Where(&ServerConfig{DiscordId: guildID}).Updates(values)
+
+	// We only expect one server to be updated at a time. Otherwise, return an error.
+	if tx.RowsAffected != 1 {
+		return fmt.Errorf("did not expect %v rows to be affected updating "+
+			"server config for server: %v", tx.RowsAffected, guildID)
+	}
+	logger.WithFields(log.Fields{"settings": values, "source": source}).Info("server config updated")
+	bot.prefixes.forget(guildID)
+	bot.recordConfigChange(ctx, s, guildID, user, source, before, logger)
+	return nil
+}
+
+// serverChannel returns an error unless a channel is in a server.
+func serverChannel(s Session, guildID string, channelID string) error {
+	channel, err := s.Channel(channelID)
+	if err != nil {
+		return fmt.Errorf("unable to look up channel %v: %w", channelID, err)
+	}
+	if channel.GuildID != guildID {
+		return fmt.Errorf("channel %v is not in this server", channelID)
+	}
+	return nil
+}
+
+// setSetting parses and sets a single setting on a server.
+func (bot *AmputatorBot) setSetting(ctx context.Context, s Session, guildID string, name string, value string,
+	user *discordgo.User, source string, logger *log.Entry) (configSetting, error) {
+	setting, ok := lookUpSetting(name)
+	if !ok {
+		return setting, fmt.Errorf("unknown setting %v", name)
+	}
+	parsed, err := setting.parse(value)
+	if err != nil {
+		return setting, err
+	}
+	return setting, bot.updateSettings(ctx, s, guildID, map[string]any{setting.Field: parsed}, user, source, logger)
+}
+
+// resetSettings sets one setting, or every setting if name is empty, back
+// to its default on a server.
+func (bot *AmputatorBot) resetSettings(ctx context.Context, s Session, guildID string, name string,
+	user *discordgo.User, source string, logger *log.Entry) error {
+	settings := configSettings
+	if name != "" {
+		setting, ok := lookUpSetting(name)
+		if !ok {
+			return fmt.Errorf("unknown setting %v", name)
+		}
+		settings = []configSetting{setting}
+	}
+	values := map[string]any{}
+	for _, setting := range settings {
+		values[setting.Field] = setting.defaultValue()
+	}
+	return bot.updateSettings(ctx, s, guildID, values, user, source, logger)
+}
+
+// settingsEmbeds shows every setting's current value, and its default if
+// it's been changed. Discord allows 25 fields per embed, so the settings
+// are split across as many embeds as they need.
+func settingsEmbeds(sc ServerConfig, language string) []*discordgo.MessageEmbed {
+	var embeds []*discordgo.MessageEmbed
+	for i, setting := range configSettings {
+		if i%maxEmbedFields == 0 {
+			embeds = append(embeds, &discordgo.MessageEmbed{})
+		}
+		value := "`" + setting.value(sc) + "`"
+		if defaultValue := setting.value(defaultServerConfig); setting.value(sc) != defaultValue {
+			value += " " + translate(language, "(default `%v`)", defaultValue)
+		}
+		embed := embeds[len(embeds)-1]
+		embed.Fields = append(embed.Fields, &discordgo.MessageEmbedField{
+			Name:   setting.Name + " · " + setting.description(language),
+			Value:  value,
+			Inline: true,
+		})
+	}
+	embeds[0].Title = translate(language, "Amputator Config")
+	return embeds
+}
+
+// settingHelpEmbed describes a setting, the values it allows and its
+// current and default values.
+func settingHelpEmbed(setting configSetting, sc ServerConfig, language string) *discordgo.MessageEmbed {
+	return &discordgo.MessageEmbed{
+		Title:       setting.Name,
+		Description: setting.description(language),
+		Fields: []*discordgo.MessageEmbedField{
+			{Name: translate(language, "Allowed values"), Value: setting.allowedValues(language)},
+			{Name: translate(language, "Current value"), Value: "`" + setting.value(sc) + "`", Inline: true},
+			{Name: translate(language, "Default value"), Value: "`" + setting.value(defaultServerConfig) + "`", Inline: true},
+		},
+	}
+}
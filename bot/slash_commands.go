@@ -0,0 +1,230 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// manageServerPermission is who can use the /config command by default.
+// Servers can change it in their integration settings.
+var manageServerPermission int64 = discordgo.PermissionManageGuild
+
+// applicationCommands returns the slash commands the bot registers.
+func applicationCommands() []*discordgo.ApplicationCommand {
+	// Discord only allows 25 fixed choices, so settings are suggested as
+	// they're typed instead
+	settingOption := func(required bool) *discordgo.ApplicationCommandOption {
+		return &discordgo.ApplicationCommandOption{
+			Type:         discordgo.ApplicationCommandOptionString,
+			Name:         "setting",
+			Description:  "The setting",
+			Required:     required,
+			Autocomplete: true,
+		}
+	}
+	dmPermission := false
+
+	return []*discordgo.ApplicationCommand{{
+		Name:                     configCommand,
+		Description:              "Change how the bot behaves in this server",
+		DefaultMemberPermissions: &manageServerPermission,
+		DMPermission:             &dmPermission,
+		Options: []*discordgo.ApplicationCommandOption{
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "get",
+				Description: "Show every setting",
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "set",
+				Description: "Change a setting",
+				Options: []*discordgo.ApplicationCommandOption{settingOption(true), {
+					Type:        discordgo.ApplicationCommandOptionString,
+					Name:        "value",
+					Description: "The new value",
+					Required:    true,
+				}},
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "reset",
+				Description: "Set a setting, or every setting, back to its default",
+				Options:     []*discordgo.ApplicationCommandOption{settingOption(false)},
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "help",
+				Description: "Describe a setting and the values it allows",
+				Options:     []*discordgo.ApplicationCommandOption{settingOption(true)},
+			},
+		},
+	}}
+}
+
+// registerApplicationCommands creates or updates the bot's slash commands.
+func (bot *AmputatorBot) registerApplicationCommands(s Session, appID string) {
+	_, err := s.ApplicationCommandBulkOverwrite(appID, "", applicationCommands())
+	if err != nil {
+		log.WithError(err).Error("unable to register slash commands")
+	}
+}
+
+// applicationCommand handles someone using one of the bot's slash commands.
+func (bot *AmputatorBot) applicationCommand(s Session, i *discordgo.InteractionCreate) {
+	data := i.ApplicationCommandData()
+	ctx, span := tracer.Start(context.Background(), "ApplicationCommand", trace.WithAttributes(
+		attribute.String("discord.guild", i.GuildID),
+		attribute.String("discord.channel", i.ChannelID),
+		attribute.String("discord.command", data.Name),
+	))
+	defer span.End()
+
+	logger := interactionLogger(i.Interaction).WithField("command", data.Name)
+	logger.Info("slash command used")
+
+	var err error
+	switch data.Name {
+	case configCommand:
+		err = bot.handleConfigSlashCommand(ctx, s, i.Interaction, logger)
+	default:
+		logger.Warn("unknown slash command used")
+		return
+	}
+
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		logger.WithError(err).Warn("problem handling slash command")
+	}
+}
+
+// autocomplete suggests values for the option of a slash command that's
+// being typed. Only settings are suggested.
+func (bot *AmputatorBot) autocomplete(s Session, i *discordgo.InteractionCreate) {
+	option := focusedOption(i.ApplicationCommandData().Options)
+	if option == nil || option.Name != "setting" {
+		return
+	}
+	typed := strings.ToLower(option.StringValue())
+
+	choices := []*discordgo.ApplicationCommandOptionChoice{}
+	for _, setting := range configSettings {
+		if strings.Contains(setting.Name, typed) && len(choices) < maxAutocompleteChoices {
+			choices = append(choices, &discordgo.ApplicationCommandOptionChoice{Name: setting.Name, Value: setting.Name})
+		}
+	}
+	err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionApplicationCommandAutocompleteResult,
+		Data: &discordgo.InteractionResponseData{Choices: choices},
+	})
+	if err != nil {
+		interactionLogger(i.Interaction).WithError(err).Warn("unable to suggest settings")
+	}
+}
+
+// focusedOption returns the option being typed, which may be nested in a
+// subcommand.
+func focusedOption(options []*discordgo.ApplicationCommandInteractionDataOption) *discordgo.ApplicationCommandInteractionDataOption {
+	for _, option := range options {
+		if option.Focused {
+			return option
+		}
+		if focused := focusedOption(option.Options); focused != nil {
+			return focused
+		}
+	}
+	return nil
+}
+
+// handleConfigSlashCommand shows or changes the server's config, replying
+// so only the person who used the command can see it.
+func (bot *AmputatorBot) handleConfigSlashCommand(ctx context.Context, s Session, i *discordgo.Interaction, logger *log.Entry) error {
+	if i.GuildID == "" {
+		return respondEphemeral(ctx, s, i, translate(languageForLocale(i.Locale), "This command only works in servers"))
+	}
+	guild, err := s.Guild(i.GuildID)
+	if err != nil {
+		return fmt.Errorf("unable to look up guild by id: %v", i.GuildID)
+	}
+
+	// Get the server config. If empty, register the server.
+	sc := bot.getServerConfig(i.GuildID)
+	if sc == defaultServerConfig {
+		err = bot.registerOrUpdateGuild(s, guild)
+		if err != nil {
+			return fmt.Errorf("unable to register guild: %w", err)
+		}
+		sc = bot.getServerConfig(i.GuildID)
+	}
+	language := responseLanguage(sc, i.Locale)
+
+	subcommand := i.ApplicationCommandData().Options[0]
+	options := map[string]string{}
+	for _, option := range subcommand.Options {
+		options[option.Name] = option.StringValue()
+	}
+	user := interactionUser(i)
+
+	var embed *discordgo.MessageEmbed
+	var embeds []*discordgo.MessageEmbed
+	switch subcommand.Name {
+	case "get":
+		embeds = settingsEmbeds(sc, language)
+	case "help":
+		setting, ok := lookUpSetting(options["setting"])
+		if !ok {
+			err = fmt.Errorf("unknown setting %v", options["setting"])
+			break
+		}
+		embed = settingHelpEmbed(setting, sc, language)
+	case "set":
+		var setting configSetting
+		setting, err = bot.setSetting(ctx, s, i.GuildID, options["setting"], options["value"], user, configSourceSlash, logger)
+		if err != nil {
+			break
+		}
+		updated := bot.getServerConfig(i.GuildID)
+		embed = &discordgo.MessageEmbed{
+			Title:       translate(language, "Setting Updated"),
+			Description: translate(language, "%v set to %v", setting.Name, setting.value(updated)),
+		}
+	case "reset":
+		if err = bot.resetSettings(ctx, s, i.GuildID, options["setting"], user, configSourceSlash, logger); err != nil {
+			break
+		}
+		description := translate(language, "Every setting was reset to its default")
+		if options["setting"] != "" {
+			description = translate(language, "%v reset to its default", options["setting"])
+		}
+		embed = &discordgo.MessageEmbed{Title: translate(language, "Setting Updated"), Description: description}
+	default:
+		err = fmt.Errorf("unknown subcommand %v", subcommand.Name)
+	}
+
+	if err != nil {
+		embed = &discordgo.MessageEmbed{
+			Title:       translate(language, "Unable to change config"),
+			Description: translateError(language, err),
+		}
+	}
+	if embeds == nil {
+		embeds = []*discordgo.MessageEmbed{embed}
+	}
+	if respondErr := s.InteractionRespond(i, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{
+			Embeds: embeds,
+			Flags:  discordgo.MessageFlagsEphemeral,
+		},
+	}, discordgo.WithContext(ctx)); respondErr != nil {
+		return respondErr
+	}
+	return err
+}
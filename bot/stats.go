@@ -11,6 +11,7 @@ type botStats struct {
 	URLsAmputated       int64  `pretty:"URLs Amputated"`
 	TopDomains          string `pretty:"Top 5 Domains"`
 	ServersWatched      int64  `pretty:"Servers Watched"`
+	RateLimited         int64  `pretty:"Messages Rate Limited"`
 }
 
 type domainStats struct {
@@ -22,8 +23,8 @@ type domainStats struct {
 // The output here is not appropriate to send to individual servers, except
 // for ServersWatched.
 func (bot *AmputatorBot) getGlobalStats() botStats {
-	var MessagesActedOn, MessagesSent, CallsToAmputatorAPI, ServersWatched int64
-	serverId := bot.DG.State.User.ID
+	var MessagesActedOn, MessagesSent, CallsToAmputatorAPI, ServersWatched, RateLimited int64
+	serverId := bot.DG.BotUser().ID
 	amputationRows := []AmputationEvent{}
 	var topDomains []domainStats
 
@@ -34,6 +35,7 @@ func (bot *AmputatorBot) getGlobalStats() botStats {
 	bot.DB.Model(&Amputation{}).Select("response_domain_name, count(response_domain_name) as count").
 		Group("response_domain_name").Order("count DESC").Find(&topDomains)
 	bot.DB.Model(&ServerRegistration{}).Where(&ServerRegistration{}).Count(&ServersWatched)
+	bot.DB.Model(&RateLimitEvent{}).Count(&RateLimited)
 
 	var topDomainsFormatted string
 	for i := 0; i < 5 && i < len(topDomains); i++ {
@@ -52,14 +54,15 @@ func (bot *AmputatorBot) getGlobalStats() botStats {
 		URLsAmputated:       int64(len(amputationRows)),
 		TopDomains:          topDomainsFormatted,
 		ServersWatched:      ServersWatched,
+		RateLimited:         RateLimited,
 	}
 }
 
 // getServerStats gets the stats for a particular server with ID serverId.
 // If you want global stats, use getGlobalStats()
 func (bot *AmputatorBot) getServerStats(serverId string) botStats {
-	var MessagesActedOn, MessagesSent, CallsToAmputatorAPI, ServersWatched int64
-	botId := bot.DG.State.User.ID
+	var MessagesActedOn, MessagesSent, CallsToAmputatorAPI, ServersWatched, RateLimited int64
+	botId := bot.DG.BotUser().ID
 	amputationRows := []AmputationEvent{}
 	var topDomains []domainStats
 
@@ -71,6 +74,7 @@ func (bot *AmputatorBot) getServerStats(serverId string) botStats {
 		Select("response_domain_name, count(response_domain_name) as count").Order("count DESC").
 		Group("response_domain_name").Find(&topDomains)
 	bot.DB.Model(&ServerRegistration{}).Where(&ServerRegistration{}).Count(&ServersWatched)
+	bot.DB.Model(&RateLimitEvent{}).Where(&RateLimitEvent{ServerID: serverId}).Count(&RateLimited)
 
 	var topDomainsFormatted string
 	for i := 0; i < 5 && i < len(topDomains); i++ {
@@ -89,5 +93,6 @@ func (bot *AmputatorBot) getServerStats(serverId string) botStats {
 		URLsAmputated:       int64(len(amputationRows)),
 		TopDomains:          topDomainsFormatted,
 		ServersWatched:      ServersWatched,
+		RateLimited:         RateLimited,
 	}
 }
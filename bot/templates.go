@@ -0,0 +1,175 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// defaultReplyTemplate is used when a server hasn't set its own and
+	// matches the reply from before templates existed.
+	defaultReplyTemplate string = "{url}"
+
+	// maxReplyTemplateLength keeps a rendered reply with a few links well
+	// within Discord's 2000 character limit for plain messages.
+	maxReplyTemplateLength int = 300
+)
+
+// templatePlaceholderRegex matches anything that looks like a placeholder so
+// unknown ones can be rejected when a template is set.
+var templatePlaceholderRegex = regexp.MustCompile(`\{[^{}]*\}`)
+
+// replyTemplatePlaceholders are the only placeholders a reply template may
+// use. Templates are plain substitutions, nothing in them is executed.
+var replyTemplatePlaceholders = []string{"{original}", "{url}", "{domain}", "{author}", "{count}"}
+
+// replyTemplateData is what the placeholders of a reply template are
+// filled in with.
+type replyTemplateData struct {
+	Author string
+	Links  []Amputation
+}
+
+// validateReplyTemplate returns an error describing why a template can't be
+// used, or nil if it can.
+func validateReplyTemplate(template string) error {
+	if strings.TrimSpace(template) == "" {
+		return fmt.Errorf("template is empty")
+	}
+	if len(template) > maxReplyTemplateLength {
+		return fmt.Errorf("template is longer than %v characters", maxReplyTemplateLength)
+	}
+	for _, placeholder := range templatePlaceholderRegex.FindAllString(template, -1) {
+		known := false
+		for _, p := range replyTemplatePlaceholders {
+			known = known || placeholder == p
+		}
+		if !known {
+			return fmt.Errorf("unknown placeholder %v", placeholder)
+		}
+	}
+	if !strings.Contains(template, "{url}") {
+		return fmt.Errorf("template must include {url}")
+	}
+	return nil
+}
+
+// renderReplyTemplate fills in a template once per amputated link, one link
+// per line. An empty template uses defaultReplyTemplate.
+func renderReplyTemplate(template string, data replyTemplateData) string {
+	if template == "" {
+		template = defaultReplyTemplate
+	}
+	lines := make([]string, 0, len(data.Links))
+	for _, link := range data.Links {
+		replacer := strings.NewReplacer(
+			"{original}", formatLink(link.RequestURL, link, false),
+			"{url}", formatLink(link.ResponseURL, link, true),
+			"{domain}", link.ResponseDomainName,
+			"{author}", data.Author,
+			"{count}", fmt.Sprintf("%v", len(data.Links)),
+		)
+		lines = append(lines, replacer.Replace(template))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// previewReplyTemplate renders a template for a single example link.
+func previewReplyTemplate(template string, author *discordgo.User) string {
+	return renderReplyTemplate(template, replyTemplateData{
+		Author: author.Mention(),
+		Links: []Amputation{{
+			RequestURL:         "https://example.com/amp/story",
+			ResponseURL:        "https://example.com/story",
+			ResponseDomainName: "example.com",
+		}},
+	})
+}
+
+// handleTemplateCommand sets, resets or previews the server's reply
+// template. Syntax:
+// (commandPrefix) template [set (template)|reset|preview]
+func (bot *AmputatorBot) handleTemplateCommand(ctx context.Context, s Session, m *discordgo.Message, logger *log.Entry) error {
+	guild, err := s.Guild(m.GuildID)
+	if err != nil {
+		return fmt.Errorf("unable to look up guild by id: %v", m.GuildID)
+	}
+
+	// Get the server config. If empty, register the server.
+	sc := bot.getServerConfig(m.GuildID)
+	if sc == defaultServerConfig {
+		err = bot.registerOrUpdateGuild(s, guild)
+		if err != nil {
+			return fmt.Errorf("unable to register guild: %w", err)
+		}
+		sc = bot.getServerConfig(m.GuildID)
+	}
+	language := responseLanguage(sc, "")
+	before := sc
+
+	// The template itself may contain spaces, so only split off the
+	// prefix, the command and the action.
+	words := strings.SplitN(m.Content, " ", 4)
+	action := "preview"
+	if len(words) > 2 {
+		action = words[2]
+	}
+
+	if (action == "set" || action == "reset") && !bot.managesServer(ctx, s, m) {
+		bot.sendMessage(ctx, s, sc.UseEmbed, sc.ReplyToOriginalMessage, m, &discordgo.MessageEmbed{
+			Title:       translate(language, "Unable to set %v", "template"),
+			Description: translateError(language, errNotServerManager),
+		}, logger)
+		return errNotServerManager
+	}
+
+	switch action {
+	case "set":
+		template := ""
+		if len(words) == 4 {
+			template = strings.TrimSpace(words[3])
+		}
+		if err := validateReplyTemplate(template); err != nil {
+			bot.sendMessage(ctx, s, sc.UseEmbed, sc.ReplyToOriginalMessage, m, &discordgo.MessageEmbed{
+				Title:       translate(language, "Invalid Template"),
+				Description: translate(language, "Placeholders: %v", strings.Join(replyTemplatePlaceholders, " ")),
+			}, logger)
+			return fmt.Errorf("invalid template: %w", err)
+		}
+		sc.ReplyTemplate = template
+	case "reset":
+		sc.ReplyTemplate = ""
+	case "preview":
+		bot.sendMessage(ctx, s, sc.UseEmbed, sc.ReplyToOriginalMessage, m, &discordgo.MessageEmbed{
+			Title:       translate(language, "Template Preview"),
+			Description: previewReplyTemplate(sc.ReplyTemplate, m.Author),
+		}, logger)
+		return nil
+	default:
+		bot.sendMessage(ctx, s, sc.UseEmbed, sc.ReplyToOriginalMessage, m, &discordgo.MessageEmbed{
+			Title:       translate(language, "Unable to set %v", "template"),
+			Description: translate(language, "See %v for usage", amputatorRepoUrl),
+		}, logger)
+		return nil
+	}
+
+	tx := bot.DB.Model(&ServerConfig{}).Where(&ServerConfig{DiscordId: guild.ID}).Update("reply_template", sc.ReplyTemplate)
+	if tx.RowsAffected != 1 {
+		return fmt.Errorf("did not expect %v rows to be affected updating "+
+			"reply template for server: %v(%v)", fmt.Sprintf("%v", tx.RowsAffected), guild.Name, guild.ID)
+	}
+
+	logger.WithField("template", sc.ReplyTemplate).Info("reply template updated")
+	bot.recordConfigChange(ctx, s, m.GuildID, m.Author, configSourceText, before, logger)
+	bot.sendMessage(ctx, s, sc.UseEmbed, sc.ReplyToOriginalMessage, m, &discordgo.MessageEmbed{
+		Title:       translate(language, "Setting Updated"),
+		Description: previewReplyTemplate(sc.ReplyTemplate, m.Author),
+	}, logger)
+
+	return nil
+}
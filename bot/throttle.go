@@ -0,0 +1,248 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/google/uuid"
+	log "github.com/sirupsen/logrus"
+)
+
+// Scopes of the limits on how often the bot answers messages.
+const (
+	userScope    string = "user"
+	channelScope string = "channel"
+	serverScope  string = "server"
+
+	// throttleInterval is how long it takes for a limit's bucket to fill
+	// back up, so limits are per this interval.
+	throttleInterval time.Duration = time.Minute
+
+	rateLimitedEmoji string = "⏳"
+)
+
+// A RateLimitEvent is recorded whenever a message is over one of a
+// server's limits.
+type RateLimitEvent struct {
+	CreatedAt time.Time
+	UUID      string `gorm:"primaryKey"`
+	ServerID  string
+	ChannelID string
+	UserID    string
+	MessageID string
+	Scope     string
+	Action    string
+}
+
+// A tokenBucket holds up to a limit's worth of tokens, and refills at the
+// limit per throttleInterval. Every message handled takes a token.
+type tokenBucket struct {
+	tokens  float64
+	limit   int
+	updated time.Time
+}
+
+// refill adds the tokens earned since the bucket was last used.
+func (b *tokenBucket) refill(now time.Time) {
+	earned := now.Sub(b.updated).Seconds() / throttleInterval.Seconds() * float64(b.limit)
+	b.tokens = min(float64(b.limit), b.tokens+earned)
+	b.updated = now
+}
+
+// A throttleLimit is one of the limits a message has to be within.
+type throttleLimit struct {
+	Scope string
+	Key   string
+	Limit int
+}
+
+// A batchedMessage is a message over a limit whose links are amputated
+// together with others later.
+type batchedMessage struct {
+	Message *discordgo.Message
+	Links   []messageLink
+}
+
+// A throttle keeps the token buckets for every user, channel and server,
+// and the messages waiting to be batched in each channel.
+type throttle struct {
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+	batches map[string][]batchedMessage
+	pruned  time.Time
+}
+
+// allow takes a token for each limit, returning the scope of the first
+// limit that's out of tokens, or an empty string if none are. Tokens are
+// only taken if every limit has one. Limits of 0 don't apply.
+func (t *throttle) allow(limits []throttleLimit, now time.Time) string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.prune(now)
+
+	var buckets []*tokenBucket
+	for _, limit := range limits {
+		if limit.Limit <= 0 {
+			continue
+		}
+		bucket, ok := t.buckets[limit.Key]
+		if !ok {
+			bucket = &tokenBucket{tokens: float64(limit.Limit), updated: now}
+			t.buckets[limit.Key] = bucket
+		}
+		bucket.limit = limit.Limit
+		bucket.refill(now)
+		if bucket.tokens < 1 {
+			return limit.Scope
+		}
+		buckets = append(buckets, bucket)
+	}
+	for _, bucket := range buckets {
+		bucket.tokens--
+	}
+	return ""
+}
+
+// prune drops the buckets that have filled back up, since they're the
+// same as new ones. It runs at most once per throttleInterval.
+func (t *throttle) prune(now time.Time) {
+	if now.Sub(t.pruned) < throttleInterval {
+		return
+	}
+	for key, bucket := range t.buckets {
+		if bucket.refill(now); bucket.tokens >= float64(bucket.limit) {
+			delete(t.buckets, key)
+		}
+	}
+	t.pruned = now
+}
+
+// batch adds a message to its channel's batch, reporting whether it's the
+// first one so the batch needs to be sent later.
+func (t *throttle) batch(message batchedMessage) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.batches[message.Message.ChannelID] = append(t.batches[message.Message.ChannelID], message)
+	return len(t.batches[message.Message.ChannelID]) == 1
+}
+
+// takeBatch removes and returns a channel's batch.
+func (t *throttle) takeBatch(channelID string) []batchedMessage {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	batch := t.batches[channelID]
+	delete(t.batches, channelID)
+	return batch
+}
+
+func newThrottle() *throttle {
+	return &throttle{
+		buckets: map[string]*tokenBucket{},
+		batches: map[string][]batchedMessage{},
+	}
+}
+
+// rateLimited reports whether a message is over one of the server's limits
+// and shouldn't be handled now. Depending on the server's config, messages
+// over a limit are dropped, reacted to, or have their links amputated in
+// one combined reply a little later. Commands have no links, so they're
+// dropped instead of batched.
+func (bot *AmputatorBot) rateLimited(ctx context.Context, s Session, m *discordgo.Message, sc ServerConfig,
+	links []messageLink, logger *log.Entry) bool {
+	limits := []throttleLimit{
+		{Scope: userScope, Key: fmt.Sprintf("%v:%v:%v", userScope, m.GuildID, m.Author.ID), Limit: sc.UserRateLimit},
+		{Scope: channelScope, Key: channelScope + ":" + m.ChannelID, Limit: sc.ChannelRateLimit},
+	}
+	if m.GuildID != "" {
+		limits = append(limits, throttleLimit{Scope: serverScope, Key: serverScope + ":" + m.GuildID, Limit: sc.ServerRateLimit})
+	}
+	scope := bot.throttles.allow(limits, time.Now())
+	if scope == "" {
+		return false
+	}
+
+	action := sc.RateLimitAction
+	if action == rateLimitBatch && len(links) == 0 {
+		action = rateLimitDrop
+	}
+	logger = logger.WithFields(log.Fields{"scope": scope, "action": action})
+	logger.Info("message is over a rate limit")
+	bot.DB.WithContext(ctx).Create(&RateLimitEvent{
+		UUID:      uuid.New().String(),
+		ServerID:  m.GuildID,
+		ChannelID: m.ChannelID,
+		UserID:    m.Author.ID,
+		MessageID: m.ID,
+		Scope:     scope,
+		Action:    action,
+	})
+
+	switch action {
+	case rateLimitReact:
+		if err := s.MessageReactionAdd(m.ChannelID, m.ID, rateLimitedEmoji, discordgo.WithContext(ctx)); err != nil {
+			logger.WithError(err).Warn("unable to react to rate limited message")
+		}
+	case rateLimitBatch:
+		if bot.throttles.batch(batchedMessage{Message: m, Links: links}) {
+			go bot.sendBatch(s, m.ChannelID)
+		}
+	}
+	return true
+}
+
+// errNotServerManager is returned for commands that change a server but
+// are from someone who can't manage it.
+var errNotServerManager = newTranslatableError("only people with the Manage Server permission can change this")
+
+// managesServer reports whether a message is from someone who can manage
+// the server. Only they can change the server's config, and their commands
+// aren't rate limited, so they can always change the limits.
+func (bot *AmputatorBot) managesServer(ctx context.Context, s Session, m *discordgo.Message) bool {
+	if m.GuildID == "" {
+		return false
+	}
+	permissions, err := s.UserChannelPermissions(m.Author.ID, m.ChannelID, discordgo.WithContext(ctx))
+	return err == nil && permissions&discordgo.PermissionManageGuild != 0
+}
+
+// sendBatch waits for more messages to be batched in a channel, then
+// amputates the links in all of them and sends one combined reply.
+func (bot *AmputatorBot) sendBatch(s Session, channelID string) {
+	time.Sleep(rateLimitBatchDelay)
+	ctx, span := tracer.Start(context.Background(), "sendBatch")
+	defer span.End()
+
+	batch := bot.throttles.takeBatch(channelID)
+	if len(batch) == 0 {
+		return
+	}
+	last := batch[len(batch)-1].Message
+	sc := bot.getMessageConfig(last)
+	if !sc.AmputationEnabled {
+		return
+	}
+	logger := messageLogger(last).WithField("batched_messages", len(batch))
+	language := responseLanguage(sc, "")
+
+	var lines []string
+	for _, batched := range batch {
+		bot.createMessageEvent("", batched.Message)
+		for _, amputation := range bot.recordAmputations(ctx, sc, batched.Message, batched.Links, logger) {
+			if amputation.ResponseURL != "" {
+				lines = append(lines, fmt.Sprintf("**%v**: %v", batched.Message.Author.Username,
+					formatLink(amputation.ResponseURL, amputation, false)))
+			}
+		}
+	}
+	if len(lines) == 0 {
+		logger.Info("unable to amputate any of the batched links")
+		return
+	}
+	bot.sendMessage(ctx, s, sc.UseEmbed, sc.ReplyToOriginalMessage, last, &discordgo.MessageEmbed{
+		Title:       translate(language, "Amputated Links"),
+		Description: digestDescription(language, lines),
+	}, logger)
+}
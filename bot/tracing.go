@@ -0,0 +1,133 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+	"gorm.io/gorm"
+)
+
+const (
+	tracerName string = "github.com/tyzbit/go-discord-amputator/bot"
+
+	// databaseSpanKey is where the span for a database call is kept
+	// between the callbacks that start and end it.
+	databaseSpanKey string = "tracing:span"
+)
+
+// tracer is used for every span the bot creates. It delegates to whichever
+// provider SetupTracing installs and is a no-op until then.
+var tracer trace.Tracer = otel.Tracer(tracerName)
+
+// SetupTracing configures the global tracer provider using the exporter
+// named in the config: "otlp", "stdout" or "none" (the default). The
+// returned function flushes and stops the provider.
+func SetupTracing(ctx context.Context, config AmputatorBotConfig) (func(context.Context) error, error) {
+	var exporter sdktrace.SpanExporter
+	var err error
+	switch strings.ToLower(config.TraceExporter) {
+	case "otlp":
+		options := []otlptracehttp.Option{}
+		if config.TraceEndpoint != "" {
+			options = append(options, otlptracehttp.WithEndpointURL(config.TraceEndpoint))
+		}
+		exporter, err = otlptracehttp.New(ctx, options...)
+	case "stdout":
+		exporter, err = stdouttrace.New(stdouttrace.WithPrettyPrint())
+	case "", "none":
+		return func(context.Context) error { return nil }, nil
+	default:
+		return nil, fmt.Errorf("unknown trace exporter: %v", config.TraceExporter)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to create %v trace exporter: %w", config.TraceExporter, err)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewWithAttributes(
+			semconv.SchemaURL,
+			semconv.ServiceName("go-discord-amputator"),
+		)),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	return provider.Shutdown, nil
+}
+
+// TracedHTTPClient wraps an HTTP client's transport so every outbound
+// request gets its own span as a child of the request's context.
+func TracedHTTPClient(c *http.Client) *http.Client {
+	transport := c.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	c.Transport = otelhttp.NewTransport(transport)
+	return c
+}
+
+// TraceDatabase adds callbacks to a database so every create, query,
+// update and delete gets its own span as a child of the statement's
+// context.
+func TraceDatabase(db *gorm.DB) error {
+	callbacks := db.Callback()
+	return errors.Join(
+		callbacks.Create().Before("gorm:create").Register("tracing:before_create", startDatabaseSpan("create")),
+		callbacks.Create().After("gorm:create").Register("tracing:after_create", endDatabaseSpan),
+		callbacks.Query().Before("gorm:query").Register("tracing:before_query", startDatabaseSpan("query")),
+		callbacks.Query().After("gorm:query").Register("tracing:after_query", endDatabaseSpan),
+		callbacks.Update().Before("gorm:update").Register("tracing:before_update", startDatabaseSpan("update")),
+		callbacks.Update().After("gorm:update").Register("tracing:after_update", endDatabaseSpan),
+		callbacks.Delete().Before("gorm:delete").Register("tracing:before_delete", startDatabaseSpan("delete")),
+		callbacks.Delete().After("gorm:delete").Register("tracing:after_delete", endDatabaseSpan),
+	)
+}
+
+// startDatabaseSpan returns a callback that starts a span for a database
+// operation.
+func startDatabaseSpan(operation string) func(*gorm.DB) {
+	return func(tx *gorm.DB) {
+		ctx, span := tracer.Start(tx.Statement.Context, "gorm."+operation, trace.WithSpanKind(trace.SpanKindClient),
+			trace.WithAttributes(
+				attribute.String("db.system", tx.Dialector.Name()),
+				attribute.String("db.operation", operation),
+				attribute.String("db.sql.table", tx.Statement.Table),
+			))
+		tx.Statement.Context = ctx
+		tx.InstanceSet(databaseSpanKey, span)
+	}
+}
+
+// endDatabaseSpan ends the span started for a database operation, with
+// the statement that ran and any error other than a missing record.
+func endDatabaseSpan(tx *gorm.DB) {
+	value, ok := tx.InstanceGet(databaseSpanKey)
+	if !ok {
+		return
+	}
+	span := value.(trace.Span)
+	span.SetAttributes(
+		attribute.String("db.statement", tx.Statement.SQL.String()),
+		attribute.Int64("db.rows_affected", tx.Statement.RowsAffected),
+	)
+	if tx.Error != nil && !errors.Is(tx.Error, gorm.ErrRecordNotFound) {
+		span.RecordError(tx.Error)
+		span.SetStatus(codes.Error, tx.Error.Error())
+	}
+	span.End()
+}
@@ -1,6 +1,7 @@
 package bot
 
 import (
+	"context"
 	"fmt"
 	"net/url"
 	"reflect"
@@ -9,6 +10,8 @@ import (
 
 	"github.com/bwmarrin/discordgo"
 	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
 )
 
 // getFieldNamesByType takes an interface as an argument
@@ -55,15 +58,16 @@ func getTagValue(i interface{}, field string, tag string) string {
 	return r.Tag.Get(tag)
 }
 
-// Returns a multiline string that pretty prints botStats.
-func structToPrettyDiscordFields(i any) []*discordgo.MessageEmbedField {
+// Returns a multiline string that pretty prints botStats. The field names
+// are translated to language.
+func structToPrettyDiscordFields(i any, language string) []*discordgo.MessageEmbedField {
 	var fields ([]*discordgo.MessageEmbedField)
 
 	stringMapSlice := convertFlatStructToSliceStringMap(i)
 
 	for _, stringMap := range stringMapSlice {
 		for key, value := range stringMap {
-			formattedKey := getTagValue(i, key, "pretty")
+			formattedKey := translate(language, getTagValue(i, key, "pretty"))
 			newField := discordgo.MessageEmbedField{
 				Name:  formattedKey,
 				Value: fmt.Sprintf("%v", value),
@@ -77,26 +81,86 @@ func structToPrettyDiscordFields(i any) []*discordgo.MessageEmbedField {
 
 // sendMessage sends a MessageEmbed or a regular message. The content of the regular
 // message is the description of the passed MessageEmbed
-func (b AmputatorBot) sendMessage(s *discordgo.Session, useEmbed bool, replyTo bool,
-	m *discordgo.Message, e *discordgo.MessageEmbed) {
+func (b AmputatorBot) sendMessage(ctx context.Context, s Session, useEmbed bool, replyTo bool,
+	m *discordgo.Message, e *discordgo.MessageEmbed, logger *log.Entry) {
+	b.sendMessageWithComponents(ctx, s, useEmbed, replyTo, m, e, nil, 0, logger)
+}
 
-	var err error
-	switch useEmbed {
-	case true:
-		_, err = s.ChannelMessageSendEmbed(m.ChannelID, e)
-		if err != nil {
-			log.Warn(err)
+// sendMessageWithComponents is sendMessage with message components such as
+// buttons and message flags attached. It returns the message that was sent,
+// or nil if it couldn't be sent.
+func (b AmputatorBot) sendMessageWithComponents(ctx context.Context, s Session, useEmbed bool, replyTo bool,
+	m *discordgo.Message, e *discordgo.MessageEmbed, components []discordgo.MessageComponent,
+	flags discordgo.MessageFlags, logger *log.Entry) *discordgo.Message {
+	ctx, span := tracer.Start(ctx, "sendMessage")
+	defer span.End()
+	span.SetAttributes(attribute.Bool("discord.embed", useEmbed), attribute.Bool("discord.reply", replyTo))
+
+	data := &discordgo.MessageSend{Components: components, Flags: flags}
+	if useEmbed {
+		data.Embeds = []*discordgo.MessageEmbed{e}
+	} else {
+		data.Content = e.Description
+		if replyTo {
+			data.Reference = m.Reference()
 		}
-	case false:
-		if !replyTo {
-			_, err = s.ChannelMessageSend(m.ChannelID, e.Description)
-		} else {
-			_, err = s.ChannelMessageSendReply(m.ChannelID, e.Description, m.Reference())
+	}
+
+	sent, err := s.ChannelMessageSendComplex(m.ChannelID, data, discordgo.WithContext(ctx))
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		logger.WithError(err).Warn("unable to send message")
+		return nil
+	}
+	return sent
+}
+
+// sendEmbeds sends one or more embeds, splitting them across messages
+// since Discord only allows ten per message. Components are attached to
+// the last message. Every message that was sent is returned.
+func (b AmputatorBot) sendEmbeds(ctx context.Context, s Session, m *discordgo.Message,
+	embeds []*discordgo.MessageEmbed, components []discordgo.MessageComponent, logger *log.Entry) []*discordgo.Message {
+	ctx, span := tracer.Start(ctx, "sendEmbeds")
+	defer span.End()
+	span.SetAttributes(attribute.Int("discord.embeds", len(embeds)))
+
+	var sent []*discordgo.Message
+	for len(embeds) > 0 {
+		batch := embeds[:min(len(embeds), maxEmbedsPerMessage)]
+		embeds = embeds[len(batch):]
+		data := &discordgo.MessageSend{Embeds: batch}
+		if len(embeds) == 0 {
+			data.Components = components
 		}
+		message, err := s.ChannelMessageSendComplex(m.ChannelID, data, discordgo.WithContext(ctx))
 		if err != nil {
-			log.Warn(err)
+			span.SetStatus(codes.Error, err.Error())
+			logger.WithError(err).Warn("unable to send embeds")
+			continue
 		}
+		sent = append(sent, message)
 	}
+	return sent
+}
+
+// missingPermissions returns the permissions out of wanted that the bot
+// doesn't have in a channel.
+func missingPermissions(ctx context.Context, s Session, channelID string, wanted int64) (int64, error) {
+	if s.BotUser() == nil {
+		return wanted, fmt.Errorf("bot user is not known yet")
+	}
+	permissions, err := s.UserChannelPermissions(s.BotUser().ID, channelID, discordgo.WithContext(ctx))
+	if err != nil {
+		return wanted, fmt.Errorf("unable to look up permissions: %w", err)
+	}
+	return wanted &^ permissions, nil
+}
+
+// reportPermissionProblem reports something the bot skipped in a server
+// because it's missing permissions, including to the server's log channel.
+func (b *AmputatorBot) reportPermissionProblem(ctx context.Context, s Session, guildID string, problem string, logger *log.Entry) {
+	logger.WithField("guild", guildID).Warn(problem)
+	b.logToServer(ctx, s, guildID, logPermissions, problem, logger)
 }
 
 // getDomainName receives a URL and returns the FQDN
@@ -106,5 +170,8 @@ func getDomainName(s string) (string, error) {
 		return "", fmt.Errorf("unable to determine domain name for url: %v", s)
 	}
 	parts := strings.Split(url.Hostname(), ".")
+	if len(parts) < 2 {
+		return "", fmt.Errorf("url has no domain name: %v", s)
+	}
 	return parts[len(parts)-2] + "." + parts[len(parts)-1], nil
 }
@@ -0,0 +1,233 @@
+// Package fakeapi is a stand-in for the Amputator API. It serves the same
+// /convert JSON shape as the real API so the bot can be exercised in tests
+// and developed offline, and it can be scripted to return specific results,
+// errors, delays or malformed JSON.
+package fakeapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	goamputate "github.com/tyzbit/go-amputate"
+)
+
+// Response is a scripted reply to a single /convert request.
+type Response struct {
+	// Canonicals are the non-AMP URLs to return. If Canonicals and Body are
+	// both empty, the canonical URL is guessed from the request URL.
+	Canonicals []string `json:"canonicals"`
+	// Status is the HTTP status code to reply with. Defaults to 200.
+	Status int `json:"status"`
+	// Delay is how long to wait before replying.
+	Delay time.Duration `json:"-"`
+	// Body, if set, is sent verbatim instead of JSON built from Canonicals.
+	// Use it to send errors or malformed JSON.
+	Body string `json:"body"`
+}
+
+// Server is an http.Handler that answers like the Amputator API.
+type Server struct {
+	mu       sync.Mutex
+	scripts  map[string][]Response
+	fallback *Response
+	requests []string
+}
+
+// New returns a Server with no scripted responses.
+func New() *Server {
+	return &Server{scripts: map[string][]Response{}}
+}
+
+// Script queues responses for a request URL. Each request for that URL
+// uses the next response; the last one is repeated once the queue is
+// exhausted.
+func (s *Server) Script(requestURL string, responses ...Response) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.scripts[requestURL] = append(s.scripts[requestURL], responses...)
+}
+
+// Fallback sets the response used for any request URL that has not been
+// scripted. Passing nil restores guessing the canonical URL.
+func (s *Server) Fallback(r *Response) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.fallback = r
+}
+
+// scriptFileResponse is a Response as written in a script file, with the
+// delay as a duration string such as "2s".
+type scriptFileResponse struct {
+	Response
+	Delay string `json:"delay"`
+}
+
+// LoadScript reads a JSON file of request URLs to lists of responses
+// and scripts them.
+func (s *Server) LoadScript(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("unable to read script: %w", err)
+	}
+	scripts := map[string][]scriptFileResponse{}
+	if err := json.Unmarshal(data, &scripts); err != nil {
+		return fmt.Errorf("unable to parse script: %w", err)
+	}
+	for requestURL, fileResponses := range scripts {
+		responses := make([]Response, 0, len(fileResponses))
+		for _, fileResponse := range fileResponses {
+			response := fileResponse.Response
+			if fileResponse.Delay != "" {
+				response.Delay, err = time.ParseDuration(fileResponse.Delay)
+				if err != nil {
+					return fmt.Errorf("unable to parse delay for %v: %w", requestURL, err)
+				}
+			}
+			responses = append(responses, response)
+		}
+		s.Script(requestURL, responses...)
+	}
+	return nil
+}
+
+// Requests returns the request URLs received so far, in order.
+func (s *Server) Requests() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string{}, s.requests...)
+}
+
+// next records a request and returns the response that should be sent.
+func (s *Server) next(requestURL string) Response {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests = append(s.requests, requestURL)
+
+	queue := s.scripts[requestURL]
+	switch {
+	case len(queue) > 1:
+		s.scripts[requestURL] = queue[1:]
+		return queue[0]
+	case len(queue) == 1:
+		return queue[0]
+	case s.fallback != nil:
+		return *s.fallback
+	}
+	return Response{}
+}
+
+// ServeHTTP implements http.Handler.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasSuffix(r.URL.Path, "/convert") {
+		http.NotFound(w, r)
+		return
+	}
+
+	requestURL := r.URL.Query().Get("q")
+	response := s.next(requestURL)
+
+	if response.Delay > 0 {
+		select {
+		case <-time.After(response.Delay):
+		case <-r.Context().Done():
+			return
+		}
+	}
+
+	status := response.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if response.Body != "" {
+		w.WriteHeader(status)
+		_, _ = w.Write([]byte(response.Body))
+		return
+	}
+
+	canonicals := response.Canonicals
+	if len(canonicals) == 0 {
+		canonicals = []string{GuessCanonical(requestURL)}
+	}
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(convertResponse(requestURL, canonicals))
+}
+
+// convertResponse builds the /convert JSON body for a request URL.
+func convertResponse(requestURL string, canonicals []string) []goamputate.AmputationResponseObject {
+	object := goamputate.AmputationResponseObject{
+		Origin: goamputate.Origin{
+			Domain:  domain(requestURL),
+			IsAmp:   true,
+			IsValid: true,
+			Url:     requestURL,
+		},
+	}
+	for _, canonical := range canonicals {
+		object.Canonicals = append(object.Canonicals, goamputate.Canonical{
+			Domain:        domain(canonical),
+			IsValid:       true,
+			Type:          "REL",
+			Url:           canonical,
+			UrlSimilarity: 1,
+		})
+	}
+	if len(object.Canonicals) > 0 {
+		object.Canonical = object.Canonicals[0]
+	}
+	return []goamputate.AmputationResponseObject{object}
+}
+
+// GuessCanonical strips the common AMP markers from a URL: amp. subdomains,
+// /amp path segments, .amp.html suffixes and amp query parameters.
+func GuessCanonical(requestURL string) string {
+	u, err := url.Parse(requestURL)
+	if err != nil {
+		return requestURL
+	}
+
+	u.Host = strings.TrimPrefix(u.Host, "amp.")
+
+	segments := []string{}
+	for _, segment := range strings.Split(u.Path, "/") {
+		if segment == "amp" {
+			continue
+		}
+		segment = strings.Replace(segment, ".amp.html", ".html", 1)
+		segments = append(segments, segment)
+	}
+	u.Path = strings.Join(segments, "/")
+	if u.Path == "" {
+		u.Path = "/"
+	}
+
+	query := u.Query()
+	query.Del("amp")
+	if strings.EqualFold(query.Get("outputType"), "amp") {
+		query.Del("outputType")
+	}
+	u.RawQuery = query.Encode()
+
+	return u.String()
+}
+
+// domain returns the second-level domain of a URL, the way the Amputator
+// API reports it.
+func domain(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	parts := strings.Split(u.Hostname(), ".")
+	if len(parts) < 2 {
+		return u.Hostname()
+	}
+	return parts[len(parts)-2]
+}
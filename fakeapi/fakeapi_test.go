@@ -0,0 +1,81 @@
+package fakeapi
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+
+	goamputate "github.com/tyzbit/go-amputate"
+)
+
+func convert(t *testing.T, server *httptest.Server, requestURL string) (int, []byte) {
+	res, err := http.Get(server.URL + "/convert?q=" + url.QueryEscape(requestURL))
+	if err != nil {
+		t.Fatalf("unable to call fake api: %v", err)
+	}
+	defer res.Body.Close()
+	body, _ := io.ReadAll(res.Body)
+	return res.StatusCode, body
+}
+
+func TestGuessCanonical(t *testing.T) {
+	for requestURL, expected := range map[string]string{
+		"https://example.com/news/amp/story":                  "https://example.com/news/story",
+		"https://amp.example.com/story/":                      "https://example.com/story/",
+		"https://example.com/story.amp.html":                  "https://example.com/story.html",
+		"https://example.com/story?amp=1":                     "https://example.com/story",
+		"https://example.com/story?outputType=amp&page=2":     "https://example.com/story?page=2",
+		"https://example.com/ampersand-guide":                 "https://example.com/ampersand-guide",
+		"https://example.com/news/amp/story?utm_source=share": "https://example.com/news/story?utm_source=share",
+	} {
+		if actual := GuessCanonical(requestURL); actual != expected {
+			t.Errorf("GuessCanonical(%v) = %v, expected %v", requestURL, actual, expected)
+		}
+	}
+}
+
+func TestScriptedResponses(t *testing.T) {
+	fake := New()
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	requestURL := "https://example.com/amp/story"
+	fake.Script(requestURL,
+		Response{Canonicals: []string{"https://example.com/real-story"}},
+		Response{Status: http.StatusInternalServerError, Body: `{"error": "down"}`},
+		Response{Body: "not json"},
+	)
+
+	_, body := convert(t, server, requestURL)
+	urls, err := goamputate.GetCanonicalUrls(body)
+	if err != nil || !reflect.DeepEqual(urls, []string{"https://example.com/real-story"}) {
+		t.Errorf("unexpected scripted canonicals: %v, err: %v", urls, err)
+	}
+
+	status, _ := convert(t, server, requestURL)
+	if status != http.StatusInternalServerError {
+		t.Errorf("expected scripted error status, got %v", status)
+	}
+
+	// The last response repeats
+	for i := 0; i < 2; i++ {
+		_, body = convert(t, server, requestURL)
+		if _, err := goamputate.GetCanonicalUrls(body); err == nil {
+			t.Error("expected malformed json to fail to parse")
+		}
+	}
+
+	// Unscripted URLs are guessed
+	_, body = convert(t, server, "https://amp.example.com/other")
+	urls, _ = goamputate.GetCanonicalUrls(body)
+	if !reflect.DeepEqual(urls, []string{"https://example.com/other"}) {
+		t.Errorf("unexpected guessed canonicals: %v", urls)
+	}
+
+	if len(fake.Requests()) != 5 {
+		t.Errorf("expected 5 requests to be recorded, got %v", fake.Requests())
+	}
+}
@@ -1,9 +1,12 @@
 package main
 
 import (
+	"context"
 	"errors"
+	"flag"
 	"fmt"
 	"io/fs"
+	"net/http"
 	"os"
 	"os/signal"
 	"path/filepath"
@@ -17,6 +20,7 @@ import (
 	"github.com/golobby/config/v3/pkg/feeder"
 	log "github.com/sirupsen/logrus"
 	bot "github.com/tyzbit/go-discord-amputator/bot"
+	"github.com/tyzbit/go-discord-amputator/fakeapi"
 	"gorm.io/driver/mysql"
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
@@ -31,6 +35,13 @@ var (
 		&bot.Amputation{},
 		&bot.AmputationEvent{},
 		&bot.MessageEvent{},
+		&bot.InteractionEvent{},
+		&bot.AmputationReport{},
+		&bot.URLOverride{},
+		&bot.RewriteRule{},
+		&bot.ChannelCheckpoint{},
+		&bot.ConfigChange{},
+		&bot.RateLimitEvent{},
 	}
 
 	sqlitePath      string        = "/var/go-discord-amputator/local.sqlite"
@@ -62,10 +73,55 @@ func init() {
 		LogLevelSelection = log.ErrorLevel
 	}
 	log.SetLevel(LogLevelSelection)
-	log.SetFormatter(&log.JSONFormatter{})
+
+	// JSON by default
+	switch {
+	case strings.EqualFold(config.LogFormat, "text"):
+		log.SetFormatter(&log.TextFormatter{FullTimestamp: true})
+	default:
+		log.SetFormatter(&log.JSONFormatter{})
+	}
+
+	// Stdout by default, otherwise LogOutput is a path to append to
+	if config.LogOutput != "" && !strings.EqualFold(config.LogOutput, "stdout") {
+		logFile, err := os.OpenFile(config.LogOutput, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0640)
+		if err != nil {
+			log.WithError(err).Error("unable to open log file ", config.LogOutput, ", logging to stdout")
+		} else {
+			log.SetOutput(logFile)
+		}
+	} else {
+		log.SetOutput(os.Stdout)
+	}
+}
+
+// runFakeAPI serves a stand-in Amputator API for offline development.
+// Usage: go-discord-amputator fake-api [-addr :8081] [-script script.json]
+func runFakeAPI(args []string) {
+	flags := flag.NewFlagSet("fake-api", flag.ExitOnError)
+	addr := flags.String("addr", ":8081", "address to listen on")
+	script := flags.String("script", "", "JSON file of request URLs to scripted responses")
+	_ = flags.Parse(args)
+
+	server := fakeapi.New()
+	if *script != "" {
+		if err := server.LoadScript(*script); err != nil {
+			log.Fatal("unable to load fake api script: ", err)
+		}
+	}
+
+	log.Info("fake amputator api listening on ", *addr)
+	if err := http.ListenAndServe(*addr, server); err != nil {
+		log.Fatal("fake amputator api stopped: ", err)
+	}
 }
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "fake-api" {
+		runFakeAPI(os.Args[2:])
+		return
+	}
+
 	var db *gorm.DB
 	var err error
 	var dbType string
@@ -106,21 +162,26 @@ func main() {
 
 	log.Info("using ", dbType, " for the database")
 
+	// Set up tracing. This is a no-op unless an exporter is configured.
+	shutdownTracing, err := bot.SetupTracing(context.Background(), config)
+	if err != nil {
+		log.Fatal("unable to set up tracing: ", err)
+	}
+	if err := bot.TraceDatabase(db); err != nil {
+		log.Fatal("unable to set up database tracing: ", err)
+	}
+
 	// Create a new Discord session using the provided bot token.
 	dg, err := discordgo.New("Bot " + config.Token)
 	if err != nil {
 		log.Fatal("error creating Discord session: ", err)
 	}
+	dg.Client = bot.TracedHTTPClient(dg.Client)
 
 	// AmputatorBot is an instance of this bot. It has many methods attached to
 	// it for controlling the bot. db is the database object, dg is the
 	// discordgo object.
-	ampBot := bot.AmputatorBot{
-		DB:         db,
-		DG:         dg,
-		Config:     config,
-		StartingUp: true,
-	}
+	ampBot := bot.NewAmputatorBot(db, bot.DiscordSession{Session: dg}, config)
 
 	// Set up DB if necessary
 	for _, schemaType := range allSchemaTypes {
@@ -138,6 +199,8 @@ func main() {
 	dg.AddHandler(ampBot.BotReady)
 	dg.AddHandler(ampBot.GuildCreate)
 	dg.AddHandler(ampBot.MessageCreate)
+	dg.AddHandler(ampBot.InteractionCreate)
+	dg.AddHandler(ampBot.RateLimit)
 
 	// We have to be explicit about what we want to receive. In addition,
 	// some intents require additional permissions, which must be granted
@@ -161,4 +224,12 @@ func main() {
 
 	// Cleanly close down the Discord session.
 	dg.Close()
+
+	// Save where each channel was left off, to catch up from there.
+	ampBot.FlushCheckpoints(context.Background())
+
+	// Flush any spans that haven't been exported yet.
+	if err := shutdownTracing(context.Background()); err != nil {
+		log.Error("unable to shut down tracing: ", err)
+	}
 }